@@ -10,6 +10,16 @@ import (
 
 const bufSize = 1000
 
+// readBufSize is the size of the buffer reading the log. Longer lines are
+// read whole all the same, growing only the line being read, so that the
+// memory held by each Analyzer, with --file-workers running several at once,
+// is bounded by the lines of the log rather than allocated up front.
+const readBufSize = 64 * 1024
+
+// maxRecordSize bounds the size of a multiline record, so a continuation
+// expression that never stops matching can't buffer the whole log.
+const maxRecordSize = 1024 * 1024
+
 type Analyzer struct {
 	Procs          int
 	Path           string
@@ -18,7 +28,25 @@ type Analyzer struct {
 	Offset         int64
 	wg             sync.WaitGroup
 	bytesRead      int64
+	truncated      int32
+	err            error
 	VerboseResults bool
+	// KeepFields keeps the fields of a Result when VerboseResults is not
+	// set, for use in the check name.
+	KeepFields bool
+	// Continuation reports whether a line belongs to the record started by
+	// the lines before it. When nil, every line is a record of its own.
+	Continuation func([]byte) bool
+	// HoldPartialLine leaves a final line without a trailing newline unread,
+	// and out of BytesRead, as it may still be being written.
+	HoldPartialLine bool
+	// HoldRecord leaves the final record unread, and out of BytesRead, when
+	// there is a Continuation, as more of its lines may still be written.
+	HoldRecord bool
+	// MaxBytes stops reading at the end of the last line that fits within
+	// that many bytes, when more than zero. A first line longer than that is
+	// read whole.
+	MaxBytes int64
 }
 
 type discardInterface interface {
@@ -51,10 +79,12 @@ func NewDiscardWriter() *DiscardWriter {
 type AnalyzerFunc func([]byte) *Result
 
 type Result struct {
-	Path   string `json:"path"`
-	Match  string `json:"match,omitempty"`
-	Err    error  `json:"error,omitempty"`
-	Offset int64  `json:"offset"`
+	Path     string            `json:"path"`
+	Match    string            `json:"match,omitempty"`
+	Patterns []string          `json:"patterns,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Err      error             `json:"error,omitempty"`
+	Offset   int64             `json:"offset"`
 }
 
 type LineMsg struct {
@@ -80,34 +110,91 @@ func (a *Analyzer) BytesRead() int64 {
 	return atomic.LoadInt64(&a.bytesRead)
 }
 
+// Truncated reports whether reading stopped at MaxBytes, before the end of
+// the log.
+func (a *Analyzer) Truncated() bool {
+	return atomic.LoadInt32(&a.truncated) == 1
+}
+
+// Err returns the error that stopped reading the log, such as corrupt
+// compressed data, once the channel returned by Go is closed.
+func (a *Analyzer) Err() error {
+	return a.err
+}
+
 func (a *Analyzer) startProducer(ctx context.Context) <-chan LineMsg {
 	logLines := make(chan LineMsg, bufSize)
 	currentOffset := a.Offset
-	reader := bufio.NewReaderSize(a.Log, 32*1024*1024)
+	reader := bufio.NewReaderSize(a.Log, readBufSize)
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
 		defer close(logLines)
+		// record holds the lines of a multiline record until a line that
+		// doesn't continue it shows up.
+		var record LineMsg
+		send := func(msg LineMsg) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case logLines <- msg:
+				return true
+			}
+		}
+		// next is the line that didn't fit within MaxBytes, if any
+		var next []byte
 		for {
 			line, err := reader.ReadBytes('\n')
 			if err != nil && err != io.EOF {
-				fatal("error while scanning log: %s: %s\n", a.Path, err)
+				a.err = err
+				return
+			}
+			if err == io.EOF && len(line) > 0 && a.HoldPartialLine {
+				break
+			}
+			if read := atomic.LoadInt64(&a.bytesRead); a.MaxBytes > 0 && read > 0 && read+int64(len(line)) > a.MaxBytes {
+				atomic.StoreInt32(&a.truncated, 1)
+				next = line
+				break
 			}
 			atomic.AddInt64(&a.bytesRead, int64(len(line)))
-			if len(line) > 0 {
-				select {
-				case <-ctx.Done():
+			if len(line) == 0 {
+				break
+			}
+			msg := LineMsg{Line: line, Offset: currentOffset}
+			currentOffset += int64(len(line))
+			if a.Continuation == nil {
+				if !send(msg) {
 					return
-				case logLines <- LineMsg{Line: line, Offset: currentOffset}:
-					currentOffset += int64(len(line))
 				}
-				if err == io.EOF {
+			} else if len(record.Line) > 0 && len(record.Line) < maxRecordSize && a.Continuation(line) {
+				record.Line = append(record.Line, line...)
+			} else {
+				if len(record.Line) > 0 && !send(record) {
 					return
 				}
-			} else {
-				return
+				record = msg
+			}
+			if err == io.EOF {
+				break
 			}
 		}
+		if len(record.Line) == 0 {
+			return
+		}
+		// the last record may be continued by lines not written yet, so
+		// it's left for the next run, from its start. When reading stopped
+		// at MaxBytes, the next line tells whether it's complete, and a
+		// record that is all there is to read is read anyway, to move on.
+		hold := a.HoldRecord && len(record.Line) < maxRecordSize
+		if next != nil {
+			hold = hold && record.Offset > a.Offset && a.Continuation(next)
+		}
+		if hold {
+			atomic.AddInt64(&a.bytesRead, -int64(len(record.Line)))
+			return
+		}
+		send(record)
 	}()
 	return logLines
 }
@@ -128,6 +215,9 @@ func (a *Analyzer) consumer(ctx context.Context, producer <-chan LineMsg, result
 				result.Offset = msg.Offset
 				if !a.VerboseResults {
 					result.Match = ""
+					if !a.KeepFields {
+						result.Fields = nil
+					}
 				}
 				select {
 				case results <- *result:
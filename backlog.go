@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// backlogMetric is the name of the metric point holding the bytes of a log
+// file left unread by --max-bytes.
+const backlogMetric = "sensu_check_log_backlog_bytes"
+
+// backlogFile is a log file that --max-bytes left at least as many bytes of
+// unread as on the previous run, as the check can't keep up with how fast
+// the file grows.
+type backlogFile struct {
+	Path    string
+	Backlog int64
+}
+
+// backlogFiles returns the files falling behind, ordered by path.
+func backlogFiles(files map[string]FileReport) []backlogFile {
+	behind := []backlogFile{}
+	for path, report := range files {
+		if report.FallingBehind {
+			behind = append(behind, backlogFile{Path: path, Backlog: report.Backlog})
+		}
+	}
+	sort.Slice(behind, func(i, j int) bool {
+		return behind[i].Path < behind[j].Path
+	})
+	return behind
+}
+
+// backlogStatus raises the status to a warning when files are falling
+// behind.
+func backlogStatus(currentStatus int, behind []backlogFile) int {
+	if len(behind) > 0 && currentStatus < sensu.CheckStateWarning {
+		return sensu.CheckStateWarning
+	}
+	return currentStatus
+}
+
+func backlogOutput(behind []backlogFile) string {
+	output := ""
+	for _, f := range behind {
+		output = output + fmt.Sprintf("File %s is falling behind, with %d bytes left unread by --max-bytes %d\n", f.Path, f.Backlog, plugin.MaxBytes)
+	}
+	return output
+}
+
+// backlogMetrics returns a metric point for each file with bytes left unread
+// by --max-bytes, tagged with the path of the file, or nil if there are none.
+func backlogMetrics(files map[string]FileReport, now time.Time) *corev2.Metrics {
+	paths := []string{}
+	for path, report := range files {
+		if report.Backlog > 0 {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	metrics := &corev2.Metrics{}
+	for _, path := range paths {
+		metrics.Points = append(metrics.Points, &corev2.MetricPoint{
+			Name:      backlogMetric,
+			Value:     float64(files[path].Backlog),
+			Timestamp: now.Unix(),
+			Tags:      []*corev2.MetricTag{{Name: "path", Value: path}},
+		})
+	}
+	return metrics
+}
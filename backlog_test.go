@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// appendLines appends n lines of 10 bytes each to a log file.
+func appendLines(t *testing.T, path string, first, n int) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	for i := first; i < first+n; i++ {
+		_, err = fmt.Fprintf(f, "INFO %04d\n", i)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, f.Close())
+}
+
+func TestProcessLogFileWithMaxBytesBacklog(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "INFO"
+	plugin.MaxBytes = 25
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, plugin.LogFile, 0, 5)
+
+	// stops after the last whole line within --max-bytes
+	eventBuf := new(bytes.Buffer)
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	assert.Equal(t, int64(30), report.Backlog)
+	assert.False(t, report.FallingBehind)
+
+	// catching up
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	assert.Equal(t, int64(10), report.Backlog)
+	assert.False(t, report.FallingBehind)
+
+	// the file grows faster than it's read
+	appendLines(t, plugin.LogFile, 5, 3)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	assert.Equal(t, int64(20), report.Backlog)
+	assert.True(t, report.FallingBehind)
+
+	// every line is read whole, once
+	plugin.MaxBytes = 0
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	assert.Equal(t, int64(0), report.Backlog)
+	for _, line := range strings.Split(strings.TrimSpace(eventBuf.String()), "\n") {
+		var result Result
+		assert.NoError(t, json.Unmarshal([]byte(line), &result))
+		assert.Equal(t, int64(0), result.Offset%10, line)
+	}
+
+	// a first line longer than --max-bytes is read whole
+	plugin.MaxBytes = 5
+	appendLines(t, plugin.LogFile, 8, 2)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	assert.Equal(t, int64(10), report.Backlog)
+}
+
+func TestBacklogOutput(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.MaxBytes = 1000
+	now := time.Now()
+	files := map[string]FileReport{
+		"/var/log/b.log": {Backlog: 5000, FallingBehind: true},
+		"/var/log/a.log": {Backlog: 200},
+		"/var/log/c.log": {},
+	}
+	behind := backlogFiles(files)
+	if assert.Len(t, behind, 1) {
+		assert.Equal(t, "/var/log/b.log", behind[0].Path)
+	}
+	assert.Equal(t, "File /var/log/b.log is falling behind, with 5000 bytes left unread by --max-bytes 1000\n", backlogOutput(behind))
+	assert.Equal(t, 1, backlogStatus(0, behind))
+	assert.Equal(t, 2, backlogStatus(2, behind))
+	assert.Equal(t, 0, backlogStatus(0, nil))
+
+	metrics := backlogMetrics(files, now)
+	if assert.NotNil(t, metrics) && assert.Len(t, metrics.Points, 2) {
+		assert.Equal(t, backlogMetric, metrics.Points[0].Name)
+		assert.Equal(t, float64(200), metrics.Points[0].Value)
+		assert.Equal(t, "/var/log/a.log", metrics.Points[0].Tags[0].Value)
+		assert.Equal(t, float64(5000), metrics.Points[1].Value)
+	}
+	assert.Nil(t, backlogMetrics(map[string]FileReport{"/var/log/c.log": {}}, now))
+}
+
+func TestExecuteCheckFallingBehind(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+	plugin.MaxBytes = 20
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, plugin.LogFile, 0, 4)
+
+	status, err := executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	appendLines(t, plugin.LogFile, 4, 2)
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionMagic maps the magic bytes at the start of a compressed file to
+// its compression.
+var compressionMagic = []struct {
+	magic       []byte
+	compression string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+}
+
+// bzip2BlockMagic and bzip2EndMagic are the magic bytes of the first block,
+// or of the end of an empty stream, that follow the "BZh" header and block
+// size of a bzip2 file.
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// detectCompression returns the compression of f going by its magic bytes,
+// or "" if it isn't compressed.
+func detectCompression(f *os.File) (string, error) {
+	b := make([]byte, 10)
+	n, err := f.ReadAt(b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(b[:n], m.magic) {
+			return m.compression, nil
+		}
+	}
+	if isBzip2(b[:n]) {
+		return "bzip2", nil
+	}
+	return "", nil
+}
+
+// isBzip2 reports whether b starts with a bzip2 header. "BZh" alone could
+// start a line of a plain log, so the block size and the magic of the first
+// block are checked too.
+func isBzip2(b []byte) bool {
+	if len(b) < 10 || !bytes.HasPrefix(b, []byte("BZh")) || b[3] < '1' || b[3] > '9' {
+		return false
+	}
+	return bytes.Equal(b[4:10], bzip2BlockMagic) || bytes.Equal(b[4:10], bzip2EndMagic)
+}
+
+// decompress returns a reader of the decompressed contents of f from its
+// start.
+func decompress(f *os.File, compression string) (io.ReadCloser, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(f)
+	case "zstd":
+		dec, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(f)), nil
+	}
+	return nil, errors.New("unknown compression " + compression)
+}
+
+// openDecompressed returns a reader of the decompressed contents of f from
+// offset, or from the start if the contents are shorter than offset, along
+// with the offset it reads from.
+func openDecompressed(f *os.File, compression string, offset int64) (io.ReadCloser, int64, error) {
+	r, err := decompress(f, compression)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset == 0 {
+		return r, 0, nil
+	}
+	n, err := io.CopyN(io.Discard, r, offset)
+	if err == nil {
+		return r, offset, nil
+	}
+	_ = r.Close()
+	if !errors.Is(err, io.EOF) || n >= offset {
+		return nil, 0, err
+	}
+	r, err = decompress(f, compression)
+	return r, 0, err
+}
+
+// decompressedSize returns the size of the decompressed contents of f.
+func decompressedSize(f *os.File, compression string) (int64, error) {
+	r, err := decompress(f, compression)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(io.Discard, r)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const compressedContent = "INFO service started\nERROR disk full\nINFO retrying\nERROR disk still full\n"
+
+func TestProcessLogFileCompressed(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+
+	logdir := t.TempDir()
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	_, err := gw.Write([]byte(compressedContent))
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+	zstded := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zstded)
+	assert.NoError(t, err)
+	_, err = zw.Write([]byte(compressedContent))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	bzipped, err := os.ReadFile("./testingdata/archived.log.bz2")
+	assert.NoError(t, err)
+
+	files := map[string][]byte{
+		"syslog.2.gz":   gzipped.Bytes(),
+		"syslog.3.zst":  zstded.Bytes(),
+		"syslog.4.bz2":  bzipped,
+		"syslog.1.copy": []byte(compressedContent),
+	}
+	for name, content := range files {
+		plugin.StateDir = t.TempDir()
+		path := filepath.Join(logdir, name)
+		assert.NoError(t, os.WriteFile(path, content, 0644))
+
+		eventBuf := new(bytes.Buffer)
+		report, err := processLogFile(path, json.NewEncoder(eventBuf))
+		assert.NoError(t, err, name)
+		assert.Equal(t, 2, report.Matches, name)
+		var result Result
+		assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+		assert.Equal(t, int64(21), result.Offset, name)
+
+		// fully read
+		report, err = processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+		assert.NoError(t, err, name)
+		assert.Equal(t, 0, report.Matches, name)
+
+		// backfill
+		plugin.ForceReadFromStart = true
+		report, err = processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+		assert.NoError(t, err, name)
+		assert.Equal(t, 2, report.Matches, name)
+		plugin.ForceReadFromStart = false
+	}
+}
+
+func TestProcessLogFileCompressedWithMaxBytes(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+	plugin.MaxBytes = 40
+
+	logdir := t.TempDir()
+	plugin.StateDir = t.TempDir()
+	path := filepath.Join(logdir, "syslog.2.gz")
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	_, err := gw.Write([]byte(compressedContent))
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, os.WriteFile(path, gzipped.Bytes(), 0644))
+
+	// read in two parts, continuing from the decompressed offset
+	report, err := processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	report, err = processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	report, err = processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+}
+
+func TestProcessLogFileCompressionMisdetected(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "error"
+	plugin.StateDir = t.TempDir()
+
+	// a plain log starting like a bzip2 header
+	logdir := t.TempDir()
+	path := filepath.Join(logdir, "app.log")
+	assert.NoError(t, os.WriteFile(path, []byte("BZhello error\nanother error\n"), 0644))
+	report, err := processLogFile(path, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+
+	// corrupt compressed data fails that file only
+	bzipped, err := os.ReadFile("./testingdata/archived.log.bz2")
+	assert.NoError(t, err)
+	corrupt := filepath.Join(logdir, "archived.log.bz2")
+	assert.NoError(t, os.WriteFile(corrupt, append(bzipped[:12:12], bytes.Repeat([]byte{0xff}, 64)...), 0644))
+	_, err = processLogFile(corrupt, json.NewEncoder(new(bytes.Buffer)))
+	assert.ErrorContains(t, err, "bzip2")
+	_, err = os.Stat(stateFilePath(corrupt, plugin.MatchExpr))
+	assert.True(t, os.IsNotExist(err))
+}
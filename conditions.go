@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fieldCondition is a single comparison against a field of a structured log
+// record, such as `level in (error,fatal)`, `msg =~ /timeout/` or
+// `duration_ms > 5000`.
+type fieldCondition struct {
+	Path   string
+	Op     string
+	Value  string
+	Values []string
+	Re     *regexp.Regexp
+}
+
+// fieldConditions are the conditions of a match expression, all of which
+// must hold for a record to match.
+type fieldConditions []fieldCondition
+
+// conditionOps are the supported comparison operators, longest first so
+// that "<=" is not mistaken for "<".
+var conditionOps = []string{"=~", "!~", "==", "!=", "<=", ">=", "=", "<", ">"}
+
+// parseConditions parses a field match expression made of one or more
+// conditions joined by AND. Values containing whitespace must be quoted,
+// regexps may be written as /re/, and `in` takes a parenthesized list.
+func parseConditions(expr string) (fieldConditions, error) {
+	conds := fieldConditions{}
+	s := strings.TrimSpace(expr)
+	for {
+		cond, rest, err := parseCondition(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field expression %q: %s", expr, err)
+		}
+		conds = append(conds, cond)
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			return conds, nil
+		}
+		keyword, next, _ := strings.Cut(rest, " ")
+		if keyword != "AND" && keyword != "and" && keyword != "&&" {
+			return nil, fmt.Errorf("invalid field expression %q: expected AND, got %q", expr, rest)
+		}
+		s = strings.TrimSpace(next)
+	}
+}
+
+func parseCondition(s string) (fieldCondition, string, error) {
+	cond := fieldCondition{}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || strings.ContainsRune("=!<>", r)
+	})
+	if end <= 0 {
+		return cond, "", fmt.Errorf("missing field name")
+	}
+	cond.Path = s[:end]
+	s = strings.TrimLeft(s[end:], " \t")
+	if strings.HasPrefix(s, "in ") || strings.HasPrefix(s, "in(") {
+		cond.Op = "in"
+		s = strings.TrimLeft(s[2:], " \t")
+		if !strings.HasPrefix(s, "(") {
+			return cond, "", fmt.Errorf("expected ( after in")
+		}
+		closing := -1
+		quoted := false
+		for i := 1; i < len(s); i++ {
+			if s[i] == '"' {
+				quoted = !quoted
+			} else if s[i] == ')' && !quoted {
+				closing = i
+				break
+			}
+		}
+		if closing < 0 {
+			return cond, "", fmt.Errorf("missing ) in list for %s", cond.Path)
+		}
+		for _, v := range splitList(s[1:closing]) {
+			cond.Values = append(cond.Values, unquote(strings.TrimSpace(v)))
+		}
+		return cond, s[closing+1:], nil
+	}
+	for _, op := range conditionOps {
+		if strings.HasPrefix(s, op) {
+			cond.Op = op
+			break
+		}
+	}
+	if cond.Op == "" {
+		return cond, "", fmt.Errorf("missing operator after %s", cond.Path)
+	}
+	s = strings.TrimLeft(s[len(cond.Op):], " \t")
+	value, rest, err := parseValue(s)
+	if err != nil {
+		return cond, "", err
+	}
+	cond.Value = value
+	if cond.Op == "=~" || cond.Op == "!~" {
+		if cond.Re, err = regexp.Compile(value); err != nil {
+			return cond, "", err
+		}
+	}
+	if cond.Op == "<" || cond.Op == "<=" || cond.Op == ">" || cond.Op == ">=" {
+		if _, ok := numericValue(cond.Path, value); !ok {
+			return cond, "", fmt.Errorf("%s %s requires a number, got %q", cond.Path, cond.Op, value)
+		}
+	}
+	return cond, rest, nil
+}
+
+// parseValue reads a /regexp/, "quoted string" or bare word from the start of
+// s and returns it along with the remainder of s.
+func parseValue(s string) (string, string, error) {
+	if s == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	if s[0] == '/' || s[0] == '"' {
+		delim := s[0]
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+				b.WriteByte(delim)
+				i++
+			case s[i] == delim:
+				return b.String(), s[i+1:], nil
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", "", fmt.Errorf("unterminated value %s", s)
+	}
+	end := strings.IndexAny(s, " \t")
+	if end < 0 {
+		return s, "", nil
+	}
+	return s[:end], s[end:], nil
+}
+
+// splitList splits a comma separated list, ignoring commas inside quotes.
+func splitList(s string) []string {
+	var values []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				values = append(values, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(values, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// analyzeRecords returns an AnalyzerFunc that parses each line into a record
+// and evaluates the field conditions of every pattern against it. The Result
+// holds the fields referenced by matching conditions, --group-by and the
+// --check-name-template, or every field of the record if allFields is set.
+// Lines that can't be parsed are handed to fallback, or skipped if fallback
+// is nil.
+func analyzeRecords(patterns []MatchPattern, excludes []string, fallback AnalyzerFunc, parse func([]byte) (map[string]interface{}, bool), allFields bool) AnalyzerFunc {
+	conds := make([]fieldConditions, len(patterns))
+	for i, p := range patterns {
+		c, err := parseConditions(p.Expr)
+		if err != nil {
+			fatal("invalid field expression for match pattern %s: %s", p.Name, err)
+		}
+		conds[i] = c
+	}
+	excludeRes := compileExcludes(excludes)
+	referenced := checkNameFields(plugin.CheckNameTemplate)
+	if plugin.GroupBy != "" {
+		referenced = append(referenced, plugin.GroupBy)
+	}
+	return func(b []byte) *Result {
+		record, ok := parse(b)
+		if !ok {
+			if fallback != nil {
+				return fallback(b)
+			}
+			return nil
+		}
+		var matched []string
+		fields := map[string]string{}
+		for i, c := range conds {
+			if c.match(record) {
+				matched = append(matched, patterns[i].Name)
+				c.fields(record, fields)
+			}
+		}
+		if len(matched) == 0 || excluded(excludeRes, b) {
+			return nil
+		}
+		if allFields {
+			for k, v := range record {
+				fields[k] = fieldString(v)
+			}
+		}
+		for _, path := range referenced {
+			if v, ok := lookupField(record, path); ok {
+				fields[path] = fieldString(v)
+			}
+		}
+		return &Result{Match: string(b), Patterns: matched, Fields: fields}
+	}
+}
+
+// match reports whether the record satisfies every condition.
+func (conds fieldConditions) match(record map[string]interface{}) bool {
+	for _, c := range conds {
+		if !c.match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// fields adds the record value of each field referenced by the conditions
+// to fields.
+func (conds fieldConditions) fields(record map[string]interface{}, fields map[string]string) {
+	for _, c := range conds {
+		if v, ok := lookupField(record, c.Path); ok {
+			fields[c.Path] = fieldString(v)
+		}
+	}
+}
+
+func (c fieldCondition) match(record map[string]interface{}) bool {
+	v, ok := lookupField(record, c.Path)
+	if !ok {
+		// a missing field is never equal to anything
+		return c.Op == "!=" || c.Op == "!~"
+	}
+	s := fieldString(v)
+	switch c.Op {
+	case "=", "==":
+		return valuesEqual(c.Path, s, c.Value)
+	case "!=":
+		return !valuesEqual(c.Path, s, c.Value)
+	case "=~":
+		return c.Re.MatchString(s)
+	case "!~":
+		return !c.Re.MatchString(s)
+	case "in":
+		for _, value := range c.Values {
+			if valuesEqual(c.Path, s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	n, ok := numericValue(c.Path, s)
+	if !ok {
+		return false
+	}
+	want, _ := numericValue(c.Path, c.Value)
+	switch c.Op {
+	case "<":
+		return n < want
+	case "<=":
+		return n <= want
+	case ">":
+		return n > want
+	case ">=":
+		return n >= want
+	}
+	return false
+}
+
+// valuesEqual compares field values as strings, or as numbers when both
+// sides are numeric so that 500 and 500.0, or err and 3 for a syslog
+// severity, are equal.
+func valuesEqual(path, a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, ok := numericValue(path, a)
+	if !ok {
+		return false
+	}
+	y, ok := numericValue(path, b)
+	return ok && x == y
+}
+
+// numericValue returns the number a field value represents. Syslog severity
+// and facility names stand for their codes.
+func numericValue(path, s string) (float64, bool) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, true
+	}
+	var names map[string]float64
+	switch path {
+	case "severity":
+		names = syslogSeverities
+	case "facility":
+		names = syslogFacilities
+	}
+	n, ok := names[strings.ToLower(s)]
+	return n, ok
+}
+
+// lookupField resolves a dotted path such as "error.kind" or "tags.0" in a
+// record. A key containing dots is matched as-is before being treated as
+// a nested path.
+func lookupField(record map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := record[path]; ok {
+		return v, true
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if v, ok := lookupNested(record[path[:i]], path[i+1:]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func lookupNested(v interface{}, path string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return lookupField(v, path)
+	case []interface{}:
+		index, rest, nested := strings.Cut(path, ".")
+		n, err := strconv.Atoi(index)
+		if err != nil || n < 0 || n >= len(v) {
+			return nil, false
+		}
+		if !nested {
+			return v[n], true
+		}
+		return lookupNested(v[n], rest)
+	}
+	return nil, false
+}
+
+// fieldString returns the string form of a field value used for comparisons.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConditions(t *testing.T) {
+	conds, err := parseConditions(`level in (error, "fatal") AND msg =~ /time\/out/ and duration_ms>5000`)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(conds))
+	assert.Equal(t, "level", conds[0].Path)
+	assert.Equal(t, "in", conds[0].Op)
+	assert.Equal(t, []string{"error", "fatal"}, conds[0].Values)
+	assert.Equal(t, "msg", conds[1].Path)
+	assert.Equal(t, "=~", conds[1].Op)
+	assert.Equal(t, "time/out", conds[1].Value)
+	assert.Equal(t, "duration_ms", conds[2].Path)
+	assert.Equal(t, ">", conds[2].Op)
+	assert.Equal(t, "5000", conds[2].Value)
+
+	conds, err = parseConditions(`msg="connection refused"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "connection refused", conds[0].Value)
+
+	for _, expr := range []string{
+		"",
+		"level",
+		"level error",
+		"level = error OR level = fatal",
+		"level in (error",
+		"msg =~ /(unclosed/",
+		"duration_ms > slow",
+		`msg = "unterminated`,
+	} {
+		_, err := parseConditions(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestFieldConditionsMatch(t *testing.T) {
+	var record map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(`{
+		"level": "error",
+		"msg": "upstream timeout",
+		"duration_ms": 5001,
+		"http.status": 502,
+		"error": {"kind": "io", "retries": [1, 2, {"code": 7}]},
+		"ok": false
+	}`))
+	dec.UseNumber()
+	assert.NoError(t, dec.Decode(&record))
+
+	tests := []struct {
+		Expr  string
+		Match bool
+	}{
+		{`level = error`, true},
+		{`level == error`, true},
+		{`level != error`, false},
+		{`level in (warn,error)`, true},
+		{`level in (warn,info)`, false},
+		{`msg =~ /timeout/`, true},
+		{`msg !~ /timeout/`, false},
+		{`duration_ms > 5000`, true},
+		{`duration_ms <= 5000`, false},
+		{`duration_ms = 5001.0`, true},
+		{`http.status >= 500`, true},
+		{`error.kind = io`, true},
+		{`error.retries.1 = 2`, true},
+		{`error.retries.2.code = 7`, true},
+		{`error.retries.5 = 2`, false},
+		{`ok = false`, true},
+		{`missing = x`, false},
+		{`missing != x`, true},
+		{`level > 1`, false},
+		{`level = error AND duration_ms < 100`, false},
+	}
+	for _, test := range tests {
+		conds, err := parseConditions(test.Expr)
+		assert.NoError(t, err, test.Expr)
+		assert.Equal(t, test.Match, conds.match(record), test.Expr)
+	}
+}
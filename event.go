@@ -1,31 +1,189 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
 
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/templates"
 )
 
-func createEvent(inputEvent *corev2.Event, status int, checkNameTemplate string, results string) (*corev2.Event, error) {
+// checkNameSource is what the --check-name-template is evaluated against: the
+// input event, along with the named captures or fields of a matching line.
+type checkNameSource struct {
+	*corev2.Event
+	Fields map[string]string
+}
+
+// newCheckNameSource returns what the check name template is evaluated
+// against, with each field the template refers to that fields lacks set to
+// the empty string, so that events not about captured values, such as for a
+// missing heartbeat, get a name of their own rather than "<no value>".
+func newCheckNameSource(event *corev2.Event, checkNameTemplate string, fields map[string]string) checkNameSource {
+	source := checkNameSource{Event: event, Fields: map[string]string{}}
+	for _, name := range checkNameFields(checkNameTemplate) {
+		source.Fields[name] = ""
+	}
+	for name, value := range fields {
+		source.Fields[name] = value
+	}
+	return source
+}
+
+// checkNameUsesFields reports whether the check name template refers to the
+// fields of matching lines, in which case an event is generated for each
+// distinct check name.
+func checkNameUsesFields(checkNameTemplate string) bool {
+	return strings.Contains(checkNameTemplate, ".Fields")
+}
+
+// checkNameFieldRe matches the fields a template refers to, as .Fields.name
+// or index .Fields "name".
+var checkNameFieldRe = regexp.MustCompile(`\.Fields\.(\w+)|index\s+\.Fields\s+"([^"]+)"`)
+
+// checkNameFields returns the names of the fields the check name template
+// refers to.
+func checkNameFields(checkNameTemplate string) []string {
+	var names []string
+	for _, m := range checkNameFieldRe.FindAllStringSubmatch(checkNameTemplate, -1) {
+		if m[1] != "" {
+			names = append(names, m[1])
+		} else {
+			names = append(names, m[2])
+		}
+	}
+	return names
+}
+
+func createEvent(inputEvent *corev2.Event, status int, checkNameTemplate string, fields map[string]string, results string) (*corev2.Event, error) {
 	if status < 0 {
 		return nil, errors.New("negative status")
 	}
 	// Let's construct the check name from template
-	checkName, err := templates.EvalTemplate("check-name", checkNameTemplate, inputEvent)
+	checkName, err := templates.EvalTemplate("check-name", checkNameTemplate, newCheckNameSource(inputEvent, checkNameTemplate, fields))
 	if err != nil {
 		return nil, err
 	}
 	outputEvent := corev2.Event{Entity: inputEvent.Entity}
 	outputEvent.Namespace = inputEvent.Namespace
-	check := inputEvent.Check
-	outputEvent.Check = check
+	// the input event is shared by every event generated in a run, so the
+	// check is copied rather than modified
+	check := *inputEvent.Check
+	outputEvent.Check = &check
 	check.Executed = time.Now().Unix()
-	check.Issued = inputEvent.Check.Issued
-	check.Command = inputEvent.Check.Command
 	check.Name = checkName
 	check.Output = results
 	check.Status = uint32(status)
 	return &outputEvent, nil
 }
+
+// resultEncoder writes a matching Result to the output of the check.
+type resultEncoder interface {
+	Encode(v interface{}) error
+}
+
+// newResultEncoder returns the encoder for matching lines written to w, as
+// JSON or using the --output-template.
+func newResultEncoder(w io.Writer) (resultEncoder, error) {
+	if plugin.OutputTemplate == "" {
+		return json.NewEncoder(w), nil
+	}
+	enc, err := newTemplateEncoder(w, plugin.OutputTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --output-template: %s", err)
+	}
+	return enc, nil
+}
+
+// templateEncoder writes each Result using the --output-template, one per line.
+type templateEncoder struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func newTemplateEncoder(w io.Writer, outputTemplate string) (*templateEncoder, error) {
+	tmpl, err := template.New("output").Option("missingkey=zero").Parse(outputTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &templateEncoder{w: w, tmpl: tmpl}, nil
+}
+
+func (e *templateEncoder) Encode(v interface{}) error {
+	if err := e.tmpl.Execute(e.w, v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// eventGroup holds the matching lines that render the same check name.
+type eventGroup struct {
+	Name   string
+	Fields map[string]string
+	Files  map[string]FileReport
+	buf    *bytes.Buffer
+	enc    resultEncoder
+}
+
+// eventRouter is a resultEncoder that files each Result under the check name
+// rendered from its fields, so that alerts can be routed by captured value.
+type eventRouter struct {
+	event             *corev2.Event
+	checkNameTemplate string
+	groups            map[string]*eventGroup
+}
+
+func newEventRouter(event *corev2.Event, checkNameTemplate string) *eventRouter {
+	return &eventRouter{
+		event:             event,
+		checkNameTemplate: checkNameTemplate,
+		groups:            map[string]*eventGroup{},
+	}
+}
+
+func (r *eventRouter) Encode(v interface{}) error {
+	result, ok := v.(Result)
+	if !ok {
+		return fmt.Errorf("unexpected result type %T", v)
+	}
+	name, err := templates.EvalTemplate("check-name", r.checkNameTemplate, newCheckNameSource(r.event, r.checkNameTemplate, result.Fields))
+	if err != nil {
+		return err
+	}
+	group, ok := r.groups[name]
+	if !ok {
+		group = &eventGroup{Name: name, Fields: result.Fields, Files: map[string]FileReport{}, buf: new(bytes.Buffer)}
+		if group.enc, err = newResultEncoder(group.buf); err != nil {
+			return err
+		}
+		r.groups[name] = group
+	}
+	report, ok := group.Files[result.Path]
+	if !ok {
+		report = FileReport{Path: result.Path, PatternMatches: map[string]int{}}
+	}
+	report.add(result)
+	group.Files[result.Path] = report
+	return group.enc.Encode(result)
+}
+
+// Groups returns the event groups ordered by check name.
+func (r *eventRouter) Groups() []*eventGroup {
+	groups := make([]*eventGroup, 0, len(r.groups))
+	for _, group := range r.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the device and inode of an open file.
+func getFileID(f *os.File, info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{Device: uint64(st.Dev), Inode: uint64(st.Ino)}, true
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the volume serial number and file index of an open file,
+// the Windows equivalent of its device and inode.
+func getFileID(f *os.File, info os.FileInfo) (fileID, bool) {
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &d); err != nil {
+		return fileID{}, false
+	}
+	return fileID{
+		Device: uint64(d.VolumeSerialNumber),
+		Inode:  uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow),
+	}, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// expandGlob returns the files matching a glob pattern such as
+// /var/log/**/app-*.log, where "**" matches any number of directories and
+// the other segments follow filepath.Match. Only the directories that can
+// match the pattern are read, skipping directories and files matching any of
+// the excludes. With --follow-symlinks, "**" also descends into symbolic
+// links to directories.
+func expandGlob(pattern string, excludes []*regexp.Regexp) ([]string, error) {
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateGlob(pattern); err != nil {
+		return nil, err
+	}
+	volume := filepath.VolumeName(pattern)
+	segments := strings.Split(strings.TrimPrefix(pattern[len(volume):], string(os.PathSeparator)), string(os.PathSeparator))
+	g := &globMatcher{
+		excludes: excludes,
+		follow:   plugin.FollowSymlinks,
+		visited:  map[string]bool{},
+		files:    []string{},
+	}
+	g.match(volume+string(os.PathSeparator), segments)
+	return g.files, nil
+}
+
+// validateGlob checks the syntax of each segment of a glob pattern.
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globMatcher collects the files matching a glob pattern.
+type globMatcher struct {
+	excludes []*regexp.Regexp
+	follow   bool
+	// visited holds the real path of each directory "**" descended into,
+	// along with the number of segments left, to avoid symbolic link loops
+	visited map[string]bool
+	files   []string
+}
+
+// match adds the files under dir matching the remaining segments of a glob
+// pattern. Directories that can't be read are skipped.
+func (g *globMatcher) match(dir string, segments []string) {
+	if excluded(g.excludes, []byte(dir)) {
+		return
+	}
+	if len(segments) == 0 {
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			g.files = append(g.files, dir)
+		}
+		return
+	}
+	segment := segments[0]
+	if segment == "**" {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			key := real + "\x00" + strconv.Itoa(len(segments))
+			if g.visited[key] {
+				return
+			}
+			g.visited[key] = true
+		}
+		g.match(dir, segments[1:])
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if g.isDir(path, entry) {
+				g.match(path, segments)
+			} else if len(segments) == 1 {
+				g.match(path, nil)
+			}
+		}
+		return
+	}
+	if !strings.ContainsAny(segment, `*?[\`) {
+		g.match(filepath.Join(dir, segment), segments[1:])
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(segment, entry.Name()); ok {
+			g.match(filepath.Join(dir, entry.Name()), segments[1:])
+		}
+	}
+}
+
+// isDir reports whether a directory entry is a directory, or a symbolic link
+// to one when following symbolic links.
+func (g *globMatcher) isDir(path string, entry os.DirEntry) bool {
+	if entry.IsDir() {
+		return true
+	}
+	if !g.follow || entry.Type()&os.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
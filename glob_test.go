@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandGlob(t *testing.T) {
+	td := t.TempDir()
+	for _, name := range []string{
+		"app-1.log",
+		"app.log",
+		"nginx/app-2.log",
+		"nginx/error.log",
+		"containers/a/b/app-3.log",
+		"containers/a/b/app-3.log.gz",
+		"containers/app-4.log/not-a-file.log",
+	} {
+		path := filepath.Join(td, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("test\n"), 0644))
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(td, "empty", "app-5.log"), 0755))
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"**/app-*.log", []string{"app-1.log", "containers/a/b/app-3.log", "nginx/app-2.log"}},
+		{"*/app-*.log", []string{"nginx/app-2.log"}},
+		{"app.log", []string{"app.log"}},
+		{"nginx/**", []string{"nginx/app-2.log", "nginx/error.log"}},
+		{"containers/**/*.log", []string{"containers/a/b/app-3.log", "containers/app-4.log/not-a-file.log"}},
+		{"missing/**/*.log", []string{}},
+		{"app-[0-9].log", []string{"app-1.log"}},
+	}
+	for _, test := range tests {
+		files, err := expandGlob(filepath.Join(td, test.pattern), nil)
+		assert.NoError(t, err, test.pattern)
+		want := make([]string, len(test.want))
+		for i, name := range test.want {
+			want[i] = filepath.Join(td, filepath.FromSlash(name))
+		}
+		sort.Strings(files)
+		assert.Equal(t, want, files, test.pattern)
+	}
+
+	_, err := expandGlob(filepath.Join(td, "app-[.log"), nil)
+	assert.Error(t, err)
+}
+
+func TestBuildLogArrayWithGlob(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	td := t.TempDir()
+	for _, name := range []string{"a/app.log", "b/app.log", "b/other.log"} {
+		path := filepath.Join(td, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("test\n"), 0644))
+	}
+	plugin.LogFile = filepath.Join(td, "a", "app.log")
+	plugin.LogGlobs = []string{filepath.Join(td, "**", "app.log"), filepath.Join(td, "b", "o*.log")}
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(td, "a", "app.log"),
+		filepath.Join(td, "b", "app.log"),
+		filepath.Join(td, "b", "other.log"),
+	}, logs)
+}
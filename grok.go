@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// grokPatterns is the bundled grok pattern library, following the standard
+// Logstash grok-patterns with lookaround and atomic groups rewritten for RE2.
+var grokPatterns = map[string]string{
+	"USERNAME":           `[a-zA-Z0-9._-]+`,
+	"USER":               `%{USERNAME}`,
+	"EMAILLOCALPART":     "[a-zA-Z0-9!#$%&'*+/=?^_`{|}~-]+(?:\\.[a-zA-Z0-9!#$%&'*+/=?^_`{|}~-]+)*",
+	"EMAILADDRESS":       `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":                `[+-]?[0-9]+`,
+	"BASE10NUM":          `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":             `%{BASE10NUM}`,
+	"BASE16NUM":          `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":             `\b[1-9][0-9]*\b`,
+	"NONNEGINT":          `\b[0-9]+\b`,
+	"WORD":               `\b\w+\b`,
+	"NOTSPACE":           `\S+`,
+	"SPACE":              `\s*`,
+	"DATA":               `.*?`,
+	"GREEDYDATA":         `.*`,
+	"QUOTEDSTRING":       "\"(?:[^\"\\\\]|\\\\.)*\"|'(?:[^'\\\\]|\\\\.)*'|`(?:[^`\\\\]|\\\\.)*`",
+	"QS":                 `%{QUOTEDSTRING}`,
+	"UUID":               `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":                `%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC}`,
+	"CISCOMAC":           `(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"WINDOWSMAC":         `(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2}`,
+	"COMMONMAC":          `(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2}`,
+	"IPV4":               `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IPV6":               `(?:(?:[0-9A-Fa-f]{1,4}:){1,4}:%{IPV4}|::(?:[fF]{4}(?::0{1,4})?:)?%{IPV4}|(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,6}:[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,5}(?::[0-9A-Fa-f]{1,4}){1,2}|(?:[0-9A-Fa-f]{1,4}:){1,4}(?::[0-9A-Fa-f]{1,4}){1,3}|(?:[0-9A-Fa-f]{1,4}:){1,3}(?::[0-9A-Fa-f]{1,4}){1,4}|(?:[0-9A-Fa-f]{1,4}:){1,2}(?::[0-9A-Fa-f]{1,4}){1,5}|[0-9A-Fa-f]{1,4}:(?::[0-9A-Fa-f]{1,4}){1,6}|(?:[0-9A-Fa-f]{1,4}:){1,7}:|:(?:(?::[0-9A-Fa-f]{1,4}){1,7}|:))(?:%[0-9A-Za-z]+)?`,
+	"IP":                 `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":           `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*(?:\.?|\b)`,
+	"IPORHOST":           `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":           `%{IPORHOST}:%{POSINT}`,
+	"PATH":               `%{UNIXPATH}|%{WINPATH}`,
+	"UNIXPATH":           `(?:/[\w_%!$@:.,+~-]*)+`,
+	"WINPATH":            `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"URIPROTO":           `[A-Za-z][A-Za-z0-9+\-.]+`,
+	"URIHOST":            `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":            `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIQUERY":           `[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPARAM":           `\?%{URIQUERY}`,
+	"URIPATHPARAM":       `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":                `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+	"MONTH":              `\b(?:[Jj]an(?:uary|uar)?|[Ff]eb(?:ruary|ruar)?|[Mm](?:a|ä)?r(?:ch|z)?|[Aa]pr(?:il)?|[Mm]a(?:y|i)?|[Jj]un(?:e|i)?|[Jj]ul(?:y|i)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo](?:c|k)?t(?:ober)?|[Nn]ov(?:ember)?|[Dd]e(?:c|z)(?:ember)?)\b`,
+	"MONTHNUM":           `0?[1-9]|1[0-2]`,
+	"MONTHNUM2":          `0[1-9]|1[0-2]`,
+	"MONTHDAY":           `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":                `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":               `(?:\d\d){1,2}`,
+	"HOUR":               `2[0123]|[01]?[0-9]`,
+	"MINUTE":             `[0-5][0-9]`,
+	"SECOND":             `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":               `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":            `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":            `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":   `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"ISO8601_SECOND":     `%{SECOND}`,
+	"TIMESTAMP_ISO8601":  `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"DATE":               `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":          `%{DATE}[- ]%{TIME}`,
+	"TZ":                 `[APMCE][SD]T|UTC`,
+	"DATESTAMP_RFC822":   `%{DAY} %{MONTH} %{MONTHDAY} %{YEAR} %{TIME} %{TZ}`,
+	"DATESTAMP_RFC2822":  `%{DAY}, %{MONTHDAY} %{MONTH} %{YEAR} %{TIME} %{ISO8601_TIMEZONE}`,
+	"DATESTAMP_OTHER":    `%{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{TZ} %{YEAR}`,
+	"DATESTAMP_EVENTLOG": `%{YEAR}%{MONTHNUM2}%{MONTHDAY}%{HOUR}%{MINUTE}%{SECOND}`,
+	"SYSLOGTIMESTAMP":    `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":               `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":         `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":         `%{IPORHOST}`,
+	"SYSLOGFACILITY":     `<%{NONNEGINT:facility}.%{NONNEGINT:priority}>`,
+	"SYSLOGBASE":         `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"HTTPDATE":           `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"LOGLEVEL":           `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo?(?:rmation)?|INFO?(?:RMATION)?|[Ww]arn?(?:ing)?|WARN?(?:ING)?|[Ee]rr?(?:or)?|ERR?(?:OR)?|[Cc]rit?(?:ical)?|CRIT?(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?`,
+	"HTTPDUSER":          `%{EMAILADDRESS}|%{USER}`,
+	"COMMONAPACHELOG":    `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG":  `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+// maxGrokDepth bounds the nesting of pattern references, so that a pattern
+// referring to itself is reported rather than expanded forever.
+const maxGrokDepth = 32
+
+// grokReference matches %{SYNTAX}, %{SYNTAX:SEMANTIC} and
+// %{SYNTAX:SEMANTIC:TYPE}. The type is accepted for compatibility, captures
+// are always strings.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::\w+)?\}`)
+
+var grokCache struct {
+	sync.Mutex
+	dir     string
+	library map[string]string
+}
+
+// grokEnabled reports whether match expressions may use grok syntax.
+func grokEnabled() bool {
+	return plugin.Grok || plugin.GrokPatternsDir != ""
+}
+
+// grokLibrary returns the bundled grok patterns together with those defined
+// in --grok-patterns-dir, which take precedence.
+func grokLibrary() (map[string]string, error) {
+	grokCache.Lock()
+	defer grokCache.Unlock()
+	if grokCache.library != nil && grokCache.dir == plugin.GrokPatternsDir {
+		return grokCache.library, nil
+	}
+	library, err := loadGrokPatterns(plugin.GrokPatternsDir)
+	if err != nil {
+		return nil, err
+	}
+	grokCache.dir = plugin.GrokPatternsDir
+	grokCache.library = library
+	return library, nil
+}
+
+// loadGrokPatterns reads every file in dir, in the Logstash format of one
+// "NAME pattern" definition per line, on top of the bundled patterns.
+func loadGrokPatterns(dir string) (map[string]string, error) {
+	library := make(map[string]string, len(grokPatterns))
+	for name, pattern := range grokPatterns {
+		library[name] = pattern
+	}
+	if dir == "" {
+		return library, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read --grok-patterns-dir: %s", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read grok patterns: %s", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, pattern, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("invalid grok pattern definition in %s: %q", entry.Name(), line)
+			}
+			library[name] = strings.TrimSpace(pattern)
+		}
+	}
+	return library, nil
+}
+
+// expandGrok replaces the grok references in expr with the RE2 regexps they
+// stand for. References with a semantic become named capture groups.
+func expandGrok(expr string, library map[string]string) (string, error) {
+	return expandGrokDepth(expr, library, 0)
+}
+
+func expandGrokDepth(expr string, library map[string]string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("grok patterns nested too deeply, check for a recursive definition")
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(expr, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		pattern, ok := library[m[1]]
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", m[1])
+			return ""
+		}
+		sub, e := expandGrokDepth(pattern, library, depth+1)
+		if e != nil {
+			err = e
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		return "(?P<" + grokGroupName(m[2]) + ">" + sub + ")"
+	})
+	return expanded, err
+}
+
+// grokGroupName turns a grok semantic such as "client" or "[http][method]"
+// into a valid RE2 group name.
+func grokGroupName(semantic string) string {
+	semantic = strings.TrimSuffix(strings.TrimPrefix(semantic, "["), "]")
+	semantic = strings.ReplaceAll(semantic, "][", "_")
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, semantic)
+}
+
+// expandPattern expands the grok syntax in the expression of a pattern when
+// grok is enabled and the expression is a regexp.
+func expandPattern(p MatchPattern) (MatchPattern, error) {
+	if !grokEnabled() || (!isTextFormat() && !p.Fallback) {
+		return p, nil
+	}
+	library, err := grokLibrary()
+	if err != nil {
+		return p, err
+	}
+	expr, err := expandGrok(p.Expr, library)
+	if err != nil {
+		return p, fmt.Errorf("match pattern %s: %s", p.Name, err)
+	}
+	p.Expr = expr
+	return p, nil
+}
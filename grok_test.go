@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandGrok(t *testing.T) {
+	library := map[string]string{
+		"WORD":  `\w+`,
+		"GREET": `hello %{WORD:who}`,
+		"LOOP":  `%{LOOP}`,
+	}
+
+	expr, err := expandGrok(`%{WORD} says %{GREET}`, library)
+	assert.NoError(t, err)
+	assert.Equal(t, `(?:\w+) says (?:hello (?P<who>\w+))`, expr)
+
+	expr, err = expandGrok(`%{WORD:[http][method]}`, library)
+	assert.NoError(t, err)
+	assert.Equal(t, `(?P<http_method>\w+)`, expr)
+
+	expr, err = expandGrok(`^plain (re2|syntax)$`, library)
+	assert.NoError(t, err)
+	assert.Equal(t, `^plain (re2|syntax)$`, expr)
+
+	_, err = expandGrok(`%{NOPE:x}`, library)
+	assert.Error(t, err)
+
+	_, err = expandGrok(`%{LOOP}`, library)
+	assert.Error(t, err)
+}
+
+func TestLoadGrokPatterns(t *testing.T) {
+	td := t.TempDir()
+	err := os.WriteFile(filepath.Join(td, "app"), []byte("# custom patterns\nAPPCODE E[0-9]{4}\n\nINT [0-9]+\n"), 0644)
+	assert.NoError(t, err)
+
+	library, err := loadGrokPatterns(td)
+	assert.NoError(t, err)
+	assert.Equal(t, `E[0-9]{4}`, library["APPCODE"])
+	assert.Equal(t, `[0-9]+`, library["INT"])
+	assert.Equal(t, grokPatterns["IPV4"], library["IPV4"])
+
+	err = os.WriteFile(filepath.Join(td, "bad"), []byte("NOPATTERN\n"), 0644)
+	assert.NoError(t, err)
+	_, err = loadGrokPatterns(td)
+	assert.Error(t, err)
+
+	_, err = loadGrokPatterns(filepath.Join(td, "missing"))
+	assert.Error(t, err)
+}
+
+func TestAnalyzePatternsWithGrok(t *testing.T) {
+	clearPlugin()
+	plugin.Grok = true
+	defer clearPlugin()
+
+	patterns := []MatchPattern{{Name: "access", Expr: `%{COMBINEDAPACHELOG}`}}
+	assert.NoError(t, validatePattern(patterns[0]))
+	fn := buildAnalyzerFunc(patterns)
+
+	line := `203.0.113.9 - frank [10/Oct/2023:13:55:36 -0700] "GET /index.html HTTP/1.1" 503 2326 "-" "curl/8.0"`
+	result := fn([]byte(line))
+	if assert.NotNil(t, result) {
+		assert.Equal(t, []string{"access"}, result.Patterns)
+		assert.Equal(t, "203.0.113.9", result.Fields["clientip"])
+		assert.Equal(t, "frank", result.Fields["auth"])
+		assert.Equal(t, "10/Oct/2023:13:55:36 -0700", result.Fields["timestamp"])
+		assert.Equal(t, "GET", result.Fields["verb"])
+		assert.Equal(t, "503", result.Fields["response"])
+		assert.Equal(t, `"curl/8.0"`, result.Fields["agent"])
+	}
+	assert.Nil(t, fn([]byte("not an access log line")))
+
+	assert.Error(t, validatePattern(MatchPattern{Name: "bad", Expr: `%{NOSUCHPATTERN}`}))
+}
+
+func TestAnalyzePatternsNamedCaptures(t *testing.T) {
+	clearPlugin()
+	patterns := []MatchPattern{
+		{Name: "first", Expr: `user=(?P<user>\w+)`},
+		{Name: "second", Expr: `user=(?P<user>\w)(?P<rest>\w*)`},
+		{Name: "plain", Expr: `user`},
+	}
+	result := AnalyzePatterns(patterns, nil)([]byte("login user=alice"))
+	if assert.NotNil(t, result) {
+		assert.Equal(t, []string{"first", "second", "plain"}, result.Patterns)
+		assert.Equal(t, map[string]string{"user": "alice", "rest": "lice"}, result.Fields)
+	}
+
+	result = AnalyzePatterns(patterns[2:], nil)([]byte("login user=alice"))
+	if assert.NotNil(t, result) {
+		assert.Nil(t, result.Fields)
+	}
+}
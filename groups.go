@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// GroupReport counts the matching lines sharing a value of the --group-by
+// field.
+type GroupReport struct {
+	Value          string
+	Matches        int
+	PatternMatches map[string]int
+}
+
+// groupCounter holds a GroupReport for each value of the --group-by field.
+type groupCounter map[string]*GroupReport
+
+func (c groupCounter) add(value string, matches int, patternMatches map[string]int) {
+	group, ok := c[value]
+	if !ok {
+		group = &GroupReport{Value: value, PatternMatches: map[string]int{}}
+		c[value] = group
+	}
+	group.Matches += matches
+	for name, n := range patternMatches {
+		group.PatternMatches[name] += n
+	}
+}
+
+// top returns the n groups with the most matching lines, or every group if n
+// is not positive.
+func (c groupCounter) top(n int) []*GroupReport {
+	groups := make([]*GroupReport, 0, len(c))
+	for _, group := range c {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Matches != groups[j].Matches {
+			return groups[i].Matches > groups[j].Matches
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	if n > 0 && len(groups) > n {
+		groups = groups[:n]
+	}
+	return groups
+}
+
+// add counts a matching Result in the report of its file, and in its group
+// when --group-by is used. Lines without the --group-by field are counted in
+// the group with an empty value.
+func (report *FileReport) add(result Result) {
+	report.Matches++
+	for _, name := range result.Patterns {
+		report.PatternMatches[name]++
+	}
+	if plugin.GroupBy == "" {
+		return
+	}
+	if report.Groups == nil {
+		report.Groups = groupCounter{}
+	}
+	patternMatches := make(map[string]int, len(result.Patterns))
+	for _, name := range result.Patterns {
+		patternMatches[name]++
+	}
+	report.Groups.add(result.Fields[plugin.GroupBy], 1, patternMatches)
+}
+
+// mergeGroups returns the groups of every file combined, as thresholds apply
+// to each group regardless of the file its matches were found in.
+func mergeGroups(files map[string]FileReport) groupCounter {
+	groups := groupCounter{}
+	for _, report := range files {
+		for value, group := range report.Groups {
+			groups.add(value, group.Matches, group.PatternMatches)
+		}
+	}
+	return groups
+}
+
+// reportsStatus holds the matches of each pattern to the thresholds of that
+// pattern, per file or, when --group-by is used, per group.
+func reportsStatus(currentStatus int, files map[string]FileReport, patterns []MatchPattern) int {
+	if plugin.GroupBy == "" {
+		for _, report := range files {
+			currentStatus = setPatternsStatus(currentStatus, report.PatternMatches, patterns)
+		}
+		return currentStatus
+	}
+	for _, group := range mergeGroups(files) {
+		currentStatus = setPatternsStatus(currentStatus, group.PatternMatches, patterns)
+	}
+	return currentStatus
+}
+
+// groupsOutput returns the number of matching lines of the top groups.
+func groupsOutput(files map[string]FileReport) string {
+	groups := mergeGroups(files)
+	output := fmt.Sprintf("Top groups by %s:\n", plugin.GroupBy)
+	for _, group := range groups.top(plugin.TopGroups) {
+		output = output + fmt.Sprintf("  %s=%q has %d matching lines\n", plugin.GroupBy, group.Value, group.Matches)
+	}
+	if plugin.TopGroups > 0 && len(groups) > plugin.TopGroups {
+		output = output + fmt.Sprintf("  and %d more groups\n", len(groups)-plugin.TopGroups)
+	}
+	return output
+}
+
+// capturesField reports whether any of the RE2 patterns has a named capture
+// group called name.
+func capturesField(patterns []MatchPattern, name string) (bool, error) {
+	for _, p := range patterns {
+		p, err := expandPattern(p)
+		if err != nil {
+			return false, err
+		}
+		re, err := regexp.Compile(p.Expr)
+		if err != nil {
+			return false, err
+		}
+		for _, subexp := range re.SubexpNames() {
+			if subexp == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCounterTop(t *testing.T) {
+	groups := groupCounter{}
+	groups.add("b", 2, map[string]int{"502": 2})
+	groups.add("a", 2, map[string]int{"502": 2})
+	groups.add("c", 5, map[string]int{"502": 5})
+	groups.add("a", 1, map[string]int{"502": 1})
+
+	top := groups.top(2)
+	if assert.Len(t, top, 2) {
+		assert.Equal(t, "c", top[0].Value)
+		assert.Equal(t, "a", top[1].Value)
+		assert.Equal(t, 3, top[1].Matches)
+		assert.Equal(t, 3, top[1].PatternMatches["502"])
+	}
+	assert.Len(t, groups.top(0), 3)
+}
+
+func TestReportsStatusWithGroupBy(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.GroupBy = "upstream"
+	plugin.TopGroups = 1
+	patterns := []MatchPattern{{Name: "502", WarningThreshold: 3, CriticalThreshold: 5}}
+
+	add := func(report *FileReport, upstream string, n int) {
+		for i := 0; i < n; i++ {
+			report.add(Result{Patterns: []string{"502"}, Fields: map[string]string{"upstream": upstream}})
+		}
+	}
+	first := FileReport{Path: "/var/log/a.log", PatternMatches: map[string]int{}}
+	second := FileReport{Path: "/var/log/b.log", PatternMatches: map[string]int{}}
+	add(&first, "10.0.0.1", 2)
+	add(&first, "10.0.0.2", 2)
+	add(&second, "10.0.0.3", 2)
+	files := map[string]FileReport{first.Path: first, second.Path: second}
+
+	// 6 matches in total, but no single upstream reaches the thresholds
+	assert.Equal(t, 0, reportsStatus(0, files, patterns))
+
+	add(&second, "10.0.0.1", 3)
+	files[second.Path] = second
+	assert.Equal(t, 2, reportsStatus(0, files, patterns))
+
+	output := summaryOutput(files, patterns)
+	assert.Contains(t, output, "Top groups by upstream:\n  upstream=\"10.0.0.1\" has 5 matching lines\n  and 2 more groups\n")
+
+	plugin.GroupBy = ""
+	assert.Equal(t, 2, reportsStatus(0, files, patterns))
+}
+
+func TestProcessLogFileWithGroupBy(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = `upstream=(?P<upstream>\S+) status=502`
+	plugin.GroupBy = "upstream"
+	plugin.WarningThreshold = 2
+	plugin.CriticalThreshold = 3
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "nginx.log")
+	plugin.StateDir = t.TempDir()
+	content := "upstream=a status=502\n" +
+		"upstream=b status=200\n" +
+		"upstream=b status=502\n" +
+		"upstream=a status=502\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+	status, err := checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	eventBuf := new(bytes.Buffer)
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Matches)
+	if assert.Len(t, report.Groups, 2) {
+		assert.Equal(t, 2, report.Groups["a"].Matches)
+		assert.Equal(t, 1, report.Groups["b"].PatternMatches[plugin.MatchExpr])
+	}
+
+	plugin.GroupBy = "client"
+	status, err = checkArgs(nil)
+	assert.Error(t, err)
+	assert.Equal(t, 2, status)
+}
+
+func TestProcessLogFileWithGroupByField(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.LogFormat = "json"
+	plugin.MatchExpr = "status == 502"
+	plugin.GroupBy = "upstream"
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "nginx.log")
+	plugin.StateDir = t.TempDir()
+	content := `{"upstream":"a","status":502}` + "\n" +
+		`{"upstream":"b","status":200}` + "\n" +
+		`{"upstream":"b","status":502}` + "\n" +
+		`{"upstream":"a","status":502}` + "\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+
+	// the field isn't referenced by the match expression
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Matches)
+	if assert.Len(t, report.Groups, 2) {
+		assert.Equal(t, 2, report.Groups["a"].Matches)
+		assert.Equal(t, 1, report.Groups["b"].Matches)
+	}
+
+	// nor is a field the check name template refers to
+	plugin.GroupBy = ""
+	defer func(checkNameTemplate string) {
+		plugin.CheckNameTemplate = checkNameTemplate
+	}(plugin.CheckNameTemplate)
+	plugin.CheckNameTemplate = "{{ .Check.Name }}-{{ .Fields.upstream }}"
+	plugin.StateDir = t.TempDir()
+	eventBuf := new(bytes.Buffer)
+	_, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	var result Result
+	assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+	assert.Equal(t, "a", result.Fields["upstream"])
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// absentPattern is a heartbeat pattern that hasn't matched for longer than
+// its AbsentFor duration.
+type absentPattern struct {
+	Pattern  MatchPattern
+	LastSeen time.Time
+}
+
+// startHeartbeats starts tracking the heartbeat patterns that aren't in the
+// state of stateFile yet. A newly monitored file, such as a new daily log
+// picked up by --log-glob or --newest, carries on from when the pattern was
+// last seen in the other files read with the same matching configuration,
+// so the new file doesn't hide an absence. Only when no file has seen the
+// pattern is it tracked as if last seen now, which gives the files its
+// AbsentFor duration to match.
+func startHeartbeats(state *State, stateFile string, patterns []MatchPattern, now time.Time) {
+	var recorded map[string]time.Time
+	for _, p := range patterns {
+		if p.AbsentFor <= 0 {
+			continue
+		}
+		if _, ok := state.LastSeen[p.Name]; ok {
+			continue
+		}
+		if recorded == nil {
+			recorded = recordedHeartbeats(stateFile)
+		}
+		if state.LastSeen == nil {
+			state.LastSeen = map[string]time.Time{}
+		}
+		state.LastSeen[p.Name] = now
+		if seen, ok := recorded[p.Name]; ok {
+			state.LastSeen[p.Name] = seen
+		}
+	}
+}
+
+// recordedHeartbeats returns when each heartbeat pattern was last seen
+// according to the other state files in the state directory with the same
+// matching configuration as stateFile, which share its suffix. State files
+// that can't be read are left out.
+func recordedHeartbeats(stateFile string) map[string]time.Time {
+	recorded := map[string]time.Time{}
+	entries, err := os.ReadDir(filepath.Dir(stateFile))
+	if err != nil {
+		return recorded
+	}
+	suffix := filepath.Ext(stateFile)
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(stateFile), entry.Name())
+		if entry.IsDir() || path == stateFile || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		state, err := getState(path)
+		if err != nil {
+			continue
+		}
+		for name, seen := range state.LastSeen {
+			if seen.After(recorded[name]) {
+				recorded[name] = seen
+			}
+		}
+	}
+	return recorded
+}
+
+// absentPatterns returns the heartbeat patterns that haven't matched in any
+// of the files for longer than their AbsentFor duration.
+func absentPatterns(files map[string]FileReport, patterns []MatchPattern, now time.Time) []absentPattern {
+	absent := []absentPattern{}
+	for _, p := range patterns {
+		if p.AbsentFor <= 0 {
+			continue
+		}
+		var lastSeen time.Time
+		for _, report := range files {
+			if seen := report.LastSeen[p.Name]; seen.After(lastSeen) {
+				lastSeen = seen
+			}
+		}
+		// not tracked in any file, such as when files are missing
+		if lastSeen.IsZero() {
+			continue
+		}
+		if now.Sub(lastSeen) > p.AbsentFor {
+			absent = append(absent, absentPattern{Pattern: p, LastSeen: lastSeen})
+		}
+	}
+	return absent
+}
+
+// silenceStatus returns a critical status, or a warning with --warning-only,
+// if silent is set because an expected line or write to a log file hasn't
+// happened in time.
+func silenceStatus(currentStatus int, silent bool) int {
+	if !silent {
+		return currentStatus
+	}
+	status := sensu.CheckStateCritical
+	if plugin.WarningOnly {
+		status = sensu.CheckStateWarning
+	}
+	if status > currentStatus {
+		return status
+	}
+	return currentStatus
+}
+
+func heartbeatOutput(absent []absentPattern, now time.Time) string {
+	output := ""
+	for _, a := range absent {
+		output = output + fmt.Sprintf("Pattern %s has not matched for %s, since %s\n", a.Pattern.Name, now.Sub(a.LastSeen).Truncate(time.Second), a.LastSeen.Format(time.RFC3339))
+	}
+	return output
+}
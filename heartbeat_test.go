@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbsentPatterns(t *testing.T) {
+	now := time.Now()
+	patterns := []MatchPattern{
+		{Name: "backup", AbsentFor: time.Hour},
+		{Name: "errors"},
+		{Name: "untracked", AbsentFor: time.Minute},
+	}
+	files := map[string]FileReport{
+		"/var/log/a.log": {LastSeen: map[string]time.Time{"backup": now.Add(-2 * time.Hour)}},
+		"/var/log/b.log": {LastSeen: map[string]time.Time{"backup": now.Add(-90 * time.Minute)}},
+	}
+	absent := absentPatterns(files, patterns, now)
+	if assert.Len(t, absent, 1) {
+		assert.Equal(t, "backup", absent[0].Pattern.Name)
+		assert.Equal(t, now.Add(-90*time.Minute), absent[0].LastSeen)
+	}
+	assert.Equal(t, 2, silenceStatus(0, len(absent) > 0))
+	assert.Equal(t, 0, silenceStatus(0, false))
+	assert.True(t, strings.HasPrefix(heartbeatOutput(absent, now), "Pattern backup has not matched for 1h30m0s, since "))
+
+	files["/var/log/b.log"].LastSeen["backup"] = now.Add(-time.Minute)
+	assert.Len(t, absentPatterns(files, patterns, now), 0)
+}
+
+func TestParsePatternAbsent(t *testing.T) {
+	clearPlugin()
+	p, err := parsePattern("name=backup,absent=30m,expr=Job finished successfully")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, p.AbsentFor)
+
+	_, err = parsePattern("absent=soon,expr=Job finished successfully")
+	assert.Error(t, err)
+	_, err = parsePattern("absent=-5m,expr=Job finished successfully")
+	assert.Error(t, err)
+}
+
+func TestExecuteCheckWithHeartbeat(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "Job finished successfully"
+	plugin.AbsentFor = "30m"
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "backup.log")
+	plugin.StateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("Job started\n"), 0644))
+	status, err := checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	// the clock starts on the first run
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	stateFile := testStateFile(t)
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	started := state.LastSeen[plugin.MatchExpr]
+	assert.False(t, started.IsZero())
+
+	// still absent on later runs without new lines
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	state, err = getState(stateFile)
+	assert.NoError(t, err)
+	assert.True(t, started.Equal(state.LastSeen[plugin.MatchExpr]))
+
+	state.LastSeen[plugin.MatchExpr] = time.Now().Add(-time.Hour)
+	assert.NoError(t, setState(state, stateFile))
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("Job finished successfully\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	state, err = getState(stateFile)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), state.LastSeen[plugin.MatchExpr], time.Minute)
+}
+
+func TestExecuteCheckWithHeartbeatInNewFile(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "Job finished successfully"
+	plugin.AbsentFor = "30m"
+
+	logdir := t.TempDir()
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "backup-1.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("Job started\n"), 0644))
+	status, err := executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	stateFile := testStateFile(t)
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	lastSeen := time.Now().Add(-time.Hour).Truncate(time.Second)
+	state.LastSeen[plugin.MatchExpr] = lastSeen
+	assert.NoError(t, setState(state, stateFile))
+
+	// the next day's file doesn't restart the clock
+	plugin.LogFile = filepath.Join(logdir, "backup-2.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("Job started\n"), 0644))
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+	state, err = getState(testStateFile(t))
+	assert.NoError(t, err)
+	assert.True(t, lastSeen.Equal(state.LastSeen[plugin.MatchExpr]))
+
+	// nor does another file read along with it
+	plugin.LogFile = ""
+	plugin.LogGlobs = []string{filepath.Join(logdir, "backup-*.log")}
+	assert.NoError(t, os.WriteFile(filepath.Join(logdir, "backup-3.log"), []byte("Job started\n"), 0644))
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+
+	// other matching configurations keep their own clock
+	plugin.LogGlobs = nil
+	plugin.LogFile = filepath.Join(logdir, "backup-2.log")
+	plugin.MatchExpr = "Job finished"
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+}
@@ -50,7 +50,7 @@ func TestSendEvent(t *testing.T) {
 	event := sensu.FixtureEvent("foo", "bar")
 	server := httptest.NewServer(testHandler{t: t, event: event})
 	defer server.Close()
-	outputEvent, err := createEvent(event, 1, "{{ .Check.Name }}-failure", "output")
+	outputEvent, err := createEvent(event, 1, "{{ .Check.Name }}-failure", nil, "output")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,3 +58,42 @@ func TestSendEvent(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCreateEventPerFields(t *testing.T) {
+	event := sensu.FixtureEvent("foo", "bar")
+	checkNameTemplate := "{{ .Check.Name }}-{{ .Fields.user }}"
+	alice, err := createEvent(event, 2, checkNameTemplate, map[string]string{"user": "alice"}, "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := createEvent(event, 1, checkNameTemplate, map[string]string{"user": "bob"}, "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := alice.Check.Name, "bar-alice"; got != want {
+		t.Errorf("bad check name: got %q, want %q", got, want)
+	}
+	if got, want := bob.Check.Name, "bar-bob"; got != want {
+		t.Errorf("bad check name: got %q, want %q", got, want)
+	}
+	if got, want := event.Check.Name, "bar"; got != want {
+		t.Errorf("input check renamed: got %q, want %q", got, want)
+	}
+	// an event without fields, such as for a missing heartbeat, isn't
+	// named after the input check, whose own result would replace it
+	absent, err := createEvent(event, 1, checkNameTemplate, nil, "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := absent.Check.Name, "bar-"; got != want {
+		t.Errorf("bad check name: got %q, want %q", got, want)
+	}
+}
+
+func TestCheckNameFields(t *testing.T) {
+	got := checkNameFields(`{{ .Check.Name }}-{{ .Fields.user }}-{{ index .Fields "kubernetes.pod" }}`)
+	want := []string{"user", "kubernetes.pod"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bad fields: got %v, want %v", got, want)
+	}
+}
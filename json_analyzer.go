@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// AnalyzeJSON returns an AnalyzerFunc that decodes each line as a JSON object
+// and evaluates the field conditions of every pattern against it. Lines that
+// are not JSON objects are handed to fallback, or skipped if fallback is nil.
+func AnalyzeJSON(patterns []MatchPattern, excludes []string, fallback AnalyzerFunc) AnalyzerFunc {
+	return analyzeRecords(patterns, excludes, fallback, parseJSONRecord, false)
+}
+
+func parseJSONRecord(b []byte) (map[string]interface{}, bool) {
+	var record map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&record); err != nil || record == nil {
+		return nil, false
+	}
+	return record, true
+}
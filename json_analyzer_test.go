@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONAnalyzer(t *testing.T) {
+	patterns := []MatchPattern{
+		{Name: "errors", Expr: "level in (error,fatal)"},
+		{Name: "timeouts", Expr: "msg =~ /timeout/"},
+	}
+	analyzer := AnalyzeJSON(patterns, []string{"health checker"}, nil)
+
+	result := analyzer([]byte(`{"msg":"upstream timeout","level":"error"}` + "\n"))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"errors", "timeouts"}, result.Patterns)
+
+	result = analyzer([]byte(`{"level":"info","msg":"request timeout"}`))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"timeouts"}, result.Patterns)
+
+	assert.Nil(t, analyzer([]byte(`{"level":"info","msg":"ok"}`)))
+	assert.Nil(t, analyzer([]byte(`{"level":"error","msg":"reset by health checker"}`)))
+	assert.Nil(t, analyzer([]byte(`panic: error timeout`)))
+	assert.Nil(t, analyzer([]byte(`["level","error"]`)))
+
+	fallback := AnalyzePatterns([]MatchPattern{{Name: "fallback", Expr: "^panic:"}}, nil)
+	analyzer = AnalyzeJSON(patterns, nil, fallback)
+	result = analyzer([]byte(`panic: runtime error`))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"fallback"}, result.Patterns)
+	assert.Nil(t, analyzer([]byte(`{"level":"info","msg":"panic: not really"}`)))
+}
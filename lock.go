@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// lockPollInterval is how often a lock held by another run of the check is
+// tried again with --lock-contention wait.
+const lockPollInterval = 100 * time.Millisecond
+
+// errLocked is returned by lockState when another run of the check holds
+// the lock on a state file.
+var errLocked = errors.New("state file is locked by another run of the check")
+
+// lockState takes an advisory lock on the state file of a log file, so that
+// overlapping runs of the check don't read the same lines. The lock is held
+// on a separate .lock file, as the state file itself is replaced on every
+// write. When another run holds the lock, errLocked is returned straight
+// away, or once --lock-timeout has passed with --lock-contention wait.
+func lockState(stateFile string) (*os.File, error) {
+	f, err := os.OpenFile(stateFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(0)
+	if plugin.LockContention == "wait" {
+		timeout, _ = parsePositiveDuration(plugin.LockTimeout)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errLocked) || !time.Now().Before(deadline) {
+			f.Close()
+			return nil, err
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// unlockState releases a lock taken by lockState.
+func unlockState(f *os.File) error {
+	err := unlockFile(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockState(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	stateFile := filepath.Join(t.TempDir(), "_var_log_app.log")
+
+	lock, err := lockState(stateFile)
+	require.NoError(t, err)
+	_, err = lockState(stateFile)
+	assert.True(t, errors.Is(err, errLocked))
+
+	// waits for the other run to release the lock
+	plugin.LockContention = "wait"
+	plugin.LockTimeout = "5s"
+	released := make(chan error)
+	go func(lock *os.File) {
+		time.Sleep(3 * lockPollInterval)
+		released <- unlockState(lock)
+	}(lock)
+	waited, err := lockState(stateFile)
+	require.NoError(t, err)
+	assert.NoError(t, <-released)
+
+	plugin.LockTimeout = "200ms"
+	start := time.Now()
+	_, err = lockState(stateFile)
+	assert.True(t, errors.Is(err, errLocked))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.NoError(t, unlockState(waited))
+}
+
+func TestProcessLogFileLocked(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "brown cow"
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("what now brown cow\n"), 0644))
+	stateFile := testStateFile(t)
+
+	// another run of the check is processing the file
+	lock, err := lockState(stateFile)
+	require.NoError(t, err)
+
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	_, err = os.Stat(stateFile)
+	assert.True(t, os.IsNotExist(err))
+
+	plugin.LockContention = "fail"
+	status, err := executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, status)
+
+	assert.NoError(t, unlockState(lock))
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+)
+
+// AnalyzeLogfmt returns an AnalyzerFunc that parses each line as logfmt
+// key=value pairs and evaluates the field conditions of every pattern against
+// them. Lines without any key=value pair are handed to fallback, or skipped if
+// fallback is nil.
+func AnalyzeLogfmt(patterns []MatchPattern, excludes []string, fallback AnalyzerFunc) AnalyzerFunc {
+	return analyzeRecords(patterns, excludes, fallback, parseLogfmtRecord, false)
+}
+
+// parseLogfmtRecord parses a line such as `level=error msg="disk full" retry`.
+// Quoted values may contain spaces and escaped quotes, and a key without a
+// value is recorded as "true".
+func parseLogfmtRecord(b []byte) (map[string]interface{}, bool) {
+	record := map[string]interface{}{}
+	s := strings.TrimRight(string(b), "\r\n")
+	pairs := 0
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			record[s] = "true"
+			break
+		}
+		key := s[:end]
+		s = s[end:]
+		if s[0] != '=' {
+			if key != "" {
+				record[key] = "true"
+			}
+			continue
+		}
+		s = s[1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var ok bool
+			if value, s, ok = parseLogfmtQuoted(s); !ok {
+				return nil, false
+			}
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		if key == "" {
+			return nil, false
+		}
+		record[key] = value
+		pairs++
+	}
+	return record, pairs > 0
+}
+
+// parseLogfmtQuoted reads a double quoted value from the start of s and
+// returns it unescaped along with the remainder of s.
+func parseLogfmtQuoted(s string) (string, string, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", false
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
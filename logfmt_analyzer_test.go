@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogfmtRecord(t *testing.T) {
+	record, ok := parseLogfmtRecord([]byte(`level=error msg="write \"failed\": disk full" req_id=42 retry empty= duration_ms=5120` + "\n"))
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{
+		"level":       "error",
+		"msg":         `write "failed": disk full`,
+		"req_id":      "42",
+		"retry":       "true",
+		"empty":       "",
+		"duration_ms": "5120",
+	}, record)
+
+	_, ok = parseLogfmtRecord([]byte("plain text line\n"))
+	assert.False(t, ok)
+	_, ok = parseLogfmtRecord([]byte(`msg="unterminated`))
+	assert.False(t, ok)
+	_, ok = parseLogfmtRecord([]byte(`=value`))
+	assert.False(t, ok)
+}
+
+func TestLogfmtAnalyzer(t *testing.T) {
+	patterns := []MatchPattern{
+		{Name: "errors", Expr: "level=error"},
+		{Name: "slow", Expr: "duration_ms>5000 AND path =~ /^\\/api/"},
+	}
+	analyzer := AnalyzeLogfmt(patterns, nil, nil)
+
+	result := analyzer([]byte(`level=error msg="upstream timeout" duration_ms=5001 path=/api/users`))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"errors", "slow"}, result.Patterns)
+	assert.Equal(t, map[string]string{
+		"level":       "error",
+		"duration_ms": "5001",
+		"path":        "/api/users",
+	}, result.Fields)
+
+	result = analyzer([]byte(`level=info duration_ms=20 path=/api/users`))
+	assert.Nil(t, result)
+	result = analyzer([]byte(`level=info duration_ms=9000 path=/health`))
+	assert.Nil(t, result)
+	assert.Nil(t, analyzer([]byte(`ERROR something went wrong`)))
+
+	fallback := AnalyzePatterns([]MatchPattern{{Name: "fallback", Expr: "ERROR"}}, nil)
+	analyzer = AnalyzeLogfmt(patterns, nil, fallback)
+	result = analyzer([]byte(`ERROR something went wrong`))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"fallback"}, result.Patterns)
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,7 +10,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
 	"strings"
 	"time"
 
@@ -26,9 +23,28 @@ type Config struct {
 	LogFile            string
 	LogFileExpr        string
 	LogPath            string
+	LogGlobs           []string
+	ExcludeFileExprs   []string
+	MaxDepth           int
+	FollowSymlinks     bool
 	StateDir           string
 	Procs              int
+	FileWorkers        int
 	MatchExpr          string
+	MatchPatterns      []string
+	ExcludeExprs       []string
+	MultilineStart     string
+	MultilineContinue  string
+	LogFormat          string
+	FallbackExpr       string
+	OutputTemplate     string
+	Grok               bool
+	GrokPatternsDir    string
+	GroupBy            string
+	TopGroups          int
+	AbsentFor          string
+	MaxFileAge         string
+	PartialLineTimeout string
 	InvertThresholds   bool
 	MaxBytes           int64
 	EventsAPI          string
@@ -37,6 +53,9 @@ type Config struct {
 	DryRun             bool
 	Verbose            bool
 	EnableStateReset   bool
+	CorruptState       string
+	LockContention     string
+	LockTimeout        string
 	MissingOK          bool
 	ForceReadFromStart bool
 	WarningThreshold   int
@@ -46,6 +65,8 @@ type Config struct {
 	CheckNameTemplate  string
 	VerboseResults     bool
 	UseLatestMtime     bool
+	Newest             int
+	ModifiedWithin     string
 }
 
 var (
@@ -63,7 +84,7 @@ var (
 			Env:       "CHECK_LOG_FILE",
 			Argument:  "log-file",
 			Shorthand: "f",
-			Usage:     "Log file to check. (Required if --log-file-expr not used)",
+			Usage:     "Log file to check. (Required if --log-file-expr or --log-glob not used)",
 			Value:     &plugin.LogFile,
 		},
 		&sensu.PluginConfigOption[string]{
@@ -71,9 +92,39 @@ var (
 			Env:       "CHECK_LOG_FILE_EXPR",
 			Argument:  "log-file-expr",
 			Shorthand: "e",
-			Usage:     "Log file regexp to check. (Required if --log-file not used)",
+			Usage:     "Log file regexp to check. (Required if --log-file or --log-glob not used)",
 			Value:     &plugin.LogFileExpr,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:                "log-glob",
+			Env:                 "CHECK_LOG_GLOB",
+			Argument:            "log-glob",
+			Usage:               "Log file glob pattern to check, such as '/var/log/**/app-*.log', where ** matches any number of directories. May be repeated. (Required if --log-file or --log-file-expr not used)",
+			Value:               &plugin.LogGlobs,
+			UseCobraStringArray: true,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:                "exclude-file-expr",
+			Env:                 "CHECK_LOG_EXCLUDE_FILE_EXPR",
+			Argument:            "exclude-file-expr",
+			Usage:               "Log file regexp for files and directories to skip when searching for log files, such as '/journal$' or '\\.gz$'. May be repeated.",
+			Value:               &plugin.ExcludeFileExprs,
+			UseCobraStringArray: true,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "follow-symlinks",
+			Env:      "CHECK_LOG_FOLLOW_SYMLINKS",
+			Argument: "follow-symlinks",
+			Usage:    "Follow symbolic links when searching for log files, and process each linked file once, keeping state by its real path.",
+			Value:    &plugin.FollowSymlinks,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "max-depth",
+			Env:      "CHECK_LOG_MAX_DEPTH",
+			Argument: "max-depth",
+			Usage:    "Maximum depth of directories under --log-path to search for log files, 1 being the files in --log-path itself (0 means unlimited).",
+			Value:    &plugin.MaxDepth,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "log-path",
 			Env:       "CHECK_LOG_PATH",
@@ -88,7 +139,7 @@ var (
 			Env:       "CHECK_LOG_STATE_DIRECTORY",
 			Argument:  "state-directory",
 			Shorthand: "d",
-			Usage:     "Directory where check will hold state for each processed log file. Note: checks using different match expressions should use different state directories to avoid conflict. (Required)",
+			Usage:     "Directory where check will hold state for each processed log file, named after the file and its matching configuration. (Required)",
 			Value:     &plugin.StateDir,
 		},
 		&sensu.PluginConfigOption[int]{
@@ -100,14 +151,118 @@ var (
 			Usage:     "Number of parallel analyzer processes per file.",
 			Value:     &plugin.Procs,
 		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "file-workers",
+			Env:      "CHECK_LOG_FILE_WORKERS",
+			Argument: "file-workers",
+			Default:  1,
+			Usage:    "Number of log files to process concurrently.",
+			Value:    &plugin.FileWorkers,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "match-expr",
 			Env:       "CHECK_LOG_MATCH_EXPR",
 			Argument:  "match-expr",
 			Shorthand: "m",
-			Usage:     "RE2 regexp matcher expression. (required)",
+			Usage:     "RE2 regexp matcher expression. (Required if --match-pattern not used)",
 			Value:     &plugin.MatchExpr,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:                "match-pattern",
+			Env:                 "CHECK_LOG_MATCH_PATTERN",
+			Argument:            "match-pattern",
+			Usage:               "Named RE2 regexp matcher with its own thresholds, in the form 'name=<name>,warning=<n>,critical=<n>,absent=<duration>,expr=<regexp>'. Name, thresholds and absent are optional, expr must come last. May be repeated. (Required if --match-expr not used)",
+			Value:               &plugin.MatchPatterns,
+			UseCobraStringArray: true,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:                "exclude-expr",
+			Env:                 "CHECK_LOG_EXCLUDE_EXPR",
+			Argument:            "exclude-expr",
+			Usage:               "RE2 regexp for lines to ignore even if they match. May be repeated.",
+			Value:               &plugin.ExcludeExprs,
+			UseCobraStringArray: true,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "multiline-start-expr",
+			Env:      "CHECK_LOG_MULTILINE_START_EXPR",
+			Argument: "multiline-start-expr",
+			Usage:    "RE2 regexp matching the first line of a multiline record. Lines that don't match are appended to the current record.",
+			Value:    &plugin.MultilineStart,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "multiline-continue-expr",
+			Env:      "CHECK_LOG_MULTILINE_CONTINUE_EXPR",
+			Argument: "multiline-continue-expr",
+			Usage:    "RE2 regexp matching continuation lines of a multiline record, such as indented stack trace frames.",
+			Value:    &plugin.MultilineContinue,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "log-format",
+			Env:      "CHECK_LOG_FORMAT",
+			Argument: "log-format",
+			Default:  "text",
+			Allow:    []string{"text", "json", "logfmt", "syslog"},
+			Usage:    "Format of log lines. With a structured format, match expressions are field expressions such as 'level in (error,fatal) AND msg =~ /timeout/' instead of RE2 regexps. (text|json|logfmt|syslog)",
+			Value:    &plugin.LogFormat,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "fallback-expr",
+			Env:      "CHECK_LOG_FALLBACK_EXPR",
+			Argument: "fallback-expr",
+			Usage:    "RE2 regexp matcher for lines that can't be parsed in the selected --log-format. Such lines are skipped if not set.",
+			Value:    &plugin.FallbackExpr,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "grok",
+			Env:      "CHECK_LOG_GROK",
+			Argument: "grok",
+			Usage:    "Allow grok syntax such as '%{IP:client} %{HTTPDATE:ts}' in RE2 match expressions, using the bundled standard pattern library.",
+			Value:    &plugin.Grok,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "grok-patterns-dir",
+			Env:      "CHECK_LOG_GROK_PATTERNS_DIR",
+			Argument: "grok-patterns-dir",
+			Usage:    "Directory of additional grok pattern definition files, one 'NAME pattern' definition per line. (implies --grok)",
+			Value:    &plugin.GrokPatternsDir,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "absent-for",
+			Env:      "CHECK_LOG_ABSENT_FOR",
+			Argument: "absent-for",
+			Usage:    "Heartbeat mode: alert when the match expression has not matched for this long, such as 30m, instead of alerting on matches.",
+			Value:    &plugin.AbsentFor,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "max-file-age",
+			Env:      "CHECK_LOG_MAX_FILE_AGE",
+			Argument: "max-file-age",
+			Usage:    "Alert when a log file has not grown for this long, such as 1h.",
+			Value:    &plugin.MaxFileAge,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "partial-line-timeout",
+			Env:      "CHECK_LOG_PARTIAL_LINE_TIMEOUT",
+			Argument: "partial-line-timeout",
+			Usage:    "Read a final line without a trailing newline, or a final multiline record, once the log file has not been modified for this long, such as 5m. By default such a line is left until it is complete, and such a record for 30s.",
+			Value:    &plugin.PartialLineTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "group-by",
+			Env:      "CHECK_LOG_GROUP_BY",
+			Argument: "group-by",
+			Usage:    "Named capture group, or field of a structured log line, to count matching lines by. Thresholds then apply to each group instead of each file.",
+			Value:    &plugin.GroupBy,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "top-groups",
+			Env:      "CHECK_LOG_TOP_GROUPS",
+			Argument: "top-groups",
+			Default:  5,
+			Usage:    "Number of groups with the most matching lines to report in the output when --group-by is used (0 means all).",
+			Value:    &plugin.TopGroups,
+		},
 		&sensu.PluginConfigOption[int]{
 			Path:      "warning-threshold",
 			Env:       "CHECK_LOG_WARNING_THRESHOLD",
@@ -181,7 +336,7 @@ var (
 			Env:       "CHECK_LOG_CHECK_NAME_TEMPLATE",
 			Argument:  "check-name-template",
 			Shorthand: "t",
-			Usage:     "Check name to use in generated events",
+			Usage:     "Check name to use in generated events. Using .Fields, such as '{{ .Check.Name }}-{{ .Fields.user }}', generates an event per distinct name.",
 			Value:     &plugin.CheckNameTemplate,
 		},
 		&sensu.PluginConfigOption[bool]{
@@ -197,9 +352,35 @@ var (
 			Env:       "CHECK_LOG_RESET_STATE",
 			Argument:  "reset-state",
 			Shorthand: "r",
-			Usage:     "Allow automatic state reset if match expression changes, instead of failing.",
+			Usage:     "Allow automatic state reset if a state file records another matching configuration, such as after editing it by hand, instead of failing.",
 			Value:     &plugin.EnableStateReset,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "corrupt-state",
+			Env:      "CHECK_LOG_CORRUPT_STATE",
+			Argument: "corrupt-state",
+			Default:  "start",
+			Allow:    []string{"start", "end", "fail"},
+			Usage:    "What to do when the state file of a log file is corrupt: move it aside with a .corrupt suffix and read the log file from its start or its end, or fail. (start|end|fail)",
+			Value:    &plugin.CorruptState,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "lock-contention",
+			Env:      "CHECK_LOG_LOCK_CONTENTION",
+			Argument: "lock-contention",
+			Default:  "skip",
+			Allow:    []string{"skip", "wait", "fail"},
+			Usage:    "What to do when another run of the check is still processing a log file: skip the file, wait up to --lock-timeout for the other run to finish, or fail with unknown status. (skip|wait|fail)",
+			Value:    &plugin.LockContention,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "lock-timeout",
+			Env:      "CHECK_LOG_LOCK_TIMEOUT",
+			Argument: "lock-timeout",
+			Default:  "30s",
+			Usage:    "How long to wait for another run of the check with --lock-contention wait, before failing with unknown status.",
+			Value:    &plugin.LockTimeout,
+		},
 		&sensu.PluginConfigOption[bool]{
 			Path:      "invert-thresholds",
 			Env:       "CHECK_LOG_INVERT_THRESHOLDS",
@@ -229,6 +410,13 @@ var (
 			Usage:     "Include detailed information about each matching line in output.",
 			Value:     &plugin.VerboseResults,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "output-template",
+			Env:      "CHECK_LOG_OUTPUT_TEMPLATE",
+			Argument: "output-template",
+			Usage:    "Go template used to format each matching line when --output-matching-string is used, for example '{{ .Fields.app }}: {{ .Fields.message }}'. Matching lines are output as JSON if not set.",
+			Value:    &plugin.OutputTemplate,
+		},
 		&sensu.PluginConfigOption[bool]{
 			Path:     "force-read-from-start",
 			Argument: "force-read-from-start",
@@ -243,49 +431,78 @@ var (
 			Usage:     "When multiple files match the log file expression, only monitor the file with the most recent modification time",
 			Value:     &plugin.UseLatestMtime,
 		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "newest",
+			Env:      "CHECK_LOG_NEWEST",
+			Argument: "newest",
+			Usage:    "When multiple files match the log file expression or glob, only monitor this many files with the most recent modification times (0 means all).",
+			Value:    &plugin.Newest,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "modified-within",
+			Env:      "CHECK_LOG_MODIFIED_WITHIN",
+			Argument: "modified-within",
+			Usage:    "Only monitor the files matching the log file expression or glob that were modified within this long, such as 1h.",
+			Value:    &plugin.ModifiedWithin,
+		},
 	}
 )
 
-// State represents the state file offset
+// State represents the state file offset, the identity of the file read,
+// when the file last grew, and when each heartbeat pattern last matched,
+// along with when the check last ran and found matches, and how often.
+// It's written as JSON, with Version set to stateVersion.
 type State struct {
-	Offset    int64
-	MatchExpr string
+	Version    int                  `json:"version"`
+	Offset     int64                `json:"offset"`
+	MatchExpr  string               `json:"match_fingerprint"`
+	LastSeen   map[string]time.Time `json:"heartbeats,omitempty"`
+	LastGrowth time.Time            `json:"last_growth"`
+	LastRun    time.Time            `json:"last_run"`
+	LastMatch  time.Time            `json:"last_match"`
+	Runs       int64                `json:"runs"`
+	Matches    int64                `json:"matches"`
+	FileID     fileID               `json:"file_id"`
+	HeadSize   int64                `json:"head_size"`
+	HeadHash   []byte               `json:"head_hash"`
+	// CompressedSize is the size of a compressed file that was read to
+	// the end, as compressed files are not expected to change
+	CompressedSize int64 `json:"compressed_size,omitempty"`
+	// Backlog is the number of bytes of a plain file left unread by
+	// --max-bytes
+	Backlog int64 `json:"backlog,omitempty"`
+	// Size is the size of the file on the previous run, to tell whether it
+	// grew even when the new bytes are left unread, such as a partial line
+	Size int64 `json:"size,omitempty"`
 }
 
-func getState(path string) (state State, err error) {
-	f, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return state, nil
-		}
-		return state, fmt.Errorf("couldn't read state file: %s", err)
-	}
-	defer func() {
-		err = f.Close()
-	}()
-
-	if err := gob.NewDecoder(f).Decode(&state); err != nil {
-		return state, fmt.Errorf("couldn't read state file: %s", err)
+// recordRun records a run of the check that found matches matching lines.
+func (s *State) recordRun(matches int, now time.Time) {
+	s.LastRun = now
+	s.Runs++
+	if matches > 0 {
+		s.Matches += int64(matches)
+		s.LastMatch = now
 	}
+}
 
-	return state, nil
+// empty reports whether no state has been recorded for the file yet.
+func (s State) empty() bool {
+	return s.Offset == 0 && s.MatchExpr == "" && len(s.LastSeen) == 0 && s.LastGrowth.IsZero()
 }
 
-func setState(cur State, path string) (err error) {
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("couldn't write state file: %s", err)
-	}
-	defer func() {
-		e := f.Close()
-		if err == nil && e != nil {
-			err = fmt.Errorf("couldn't close state file: %s", err)
-		}
-	}()
-	if err := gob.NewEncoder(f).Encode(cur); err != nil {
-		return fmt.Errorf("couldn't write state file: %s", err)
-	}
-	return nil
+// FileReport summarizes the matches found while processing a single log file.
+type FileReport struct {
+	Path           string
+	Matches        int
+	PatternMatches map[string]int
+	Groups         groupCounter
+	LastSeen       map[string]time.Time
+	LastGrowth     time.Time
+	// Backlog is the number of bytes left unread by --max-bytes, and
+	// FallingBehind is set when that is no less than on the previous run
+	Backlog       int64
+	FallingBehind bool
 }
 
 func fatal(formatter string, args ...interface{}) {
@@ -309,8 +526,33 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if event == nil && !plugin.DisableEvent {
 		return sensu.CheckStateCritical, fmt.Errorf("--disable-event-generation not selected but event missing from stdin")
 	}
-	if plugin.LogFileExpr == "" && plugin.LogFile == "" {
-		return sensu.CheckStateCritical, fmt.Errorf("at least one of --log-file or --log-file-expr must be specified")
+	if plugin.LogFileExpr == "" && plugin.LogFile == "" && len(plugin.LogGlobs) == 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("at least one of --log-file, --log-file-expr or --log-glob must be specified")
+	}
+	for _, pattern := range plugin.LogGlobs {
+		if err := validateGlob(pattern); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --log-glob %s: %s", pattern, err)
+		}
+	}
+	if _, err := compileFileExcludes(); err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	if plugin.MaxDepth < 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("--max-depth must not be negative")
+	}
+	if plugin.FileWorkers < 1 {
+		return sensu.CheckStateCritical, fmt.Errorf("--file-workers must be at least 1")
+	}
+	if plugin.Newest < 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("--newest must not be negative")
+	}
+	if plugin.UseLatestMtime && plugin.Newest > 1 {
+		return sensu.CheckStateCritical, fmt.Errorf("--mtime and --newest options conflict, cannot use both")
+	}
+	if plugin.ModifiedWithin != "" {
+		if _, err := parsePositiveDuration(plugin.ModifiedWithin); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --modified-within: %s", err)
+		}
 	}
 	if plugin.LogFileExpr != "" && plugin.LogPath == "" {
 		return sensu.CheckStateCritical, fmt.Errorf("--log-path must be specified if --log-file-expr is used")
@@ -318,8 +560,71 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if plugin.StateDir == "" {
 		return sensu.CheckStateCritical, fmt.Errorf("--state-directory not specified")
 	}
-	if plugin.MatchExpr == "" {
-		return sensu.CheckStateCritical, fmt.Errorf("--match-expr not specified")
+	if plugin.MatchExpr == "" && len(plugin.MatchPatterns) == 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("at least one of --match-expr or --match-pattern must be specified")
+	}
+	patterns, err := buildPatterns()
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	for i, p := range patterns {
+		if err := validatePattern(p); err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		// the --match-expr and fallback patterns use the global thresholds checked above
+		if (i == 0 && plugin.MatchExpr != "") || p.Fallback || p.AbsentFor > 0 {
+			continue
+		}
+		if plugin.InvertThresholds {
+			if p.WarningThreshold <= p.CriticalThreshold {
+				return sensu.CheckStateCritical, fmt.Errorf("match pattern %s: warning threshold must be greater than critical threshold when --invert-thresholds is in use", p.Name)
+			}
+		} else if p.WarningThreshold >= p.CriticalThreshold {
+			return sensu.CheckStateCritical, fmt.Errorf("match pattern %s: warning threshold must be less than critical threshold", p.Name)
+		}
+	}
+	for _, expr := range plugin.ExcludeExprs {
+		if _, err := regexp.Compile(expr); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --exclude-expr %s: %s", expr, err)
+		}
+	}
+	if plugin.MultilineStart != "" && plugin.MultilineContinue != "" {
+		return sensu.CheckStateCritical, fmt.Errorf("--multiline-start-expr and --multiline-continue-expr options conflict, cannot use both")
+	}
+	if _, err := multilineContinuation(); err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	if plugin.GroupBy != "" && isTextFormat() {
+		ok, err := capturesField(patterns, plugin.GroupBy)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		if !ok {
+			return sensu.CheckStateCritical, fmt.Errorf("--group-by %s is not a named capture group of any match pattern", plugin.GroupBy)
+		}
+	}
+	if plugin.MaxFileAge != "" {
+		if _, err := parsePositiveDuration(plugin.MaxFileAge); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --max-file-age: %s", err)
+		}
+	}
+	if plugin.LockContention == "wait" {
+		if _, err := parsePositiveDuration(plugin.LockTimeout); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --lock-timeout: %s", err)
+		}
+	}
+	if plugin.PartialLineTimeout != "" {
+		if _, err := parsePositiveDuration(plugin.PartialLineTimeout); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --partial-line-timeout: %s", err)
+		}
+	}
+	if plugin.TopGroups < 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("--top-groups must not be negative")
+	}
+	if plugin.OutputTemplate != "" {
+		if _, err := newTemplateEncoder(io.Discard, plugin.OutputTemplate); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --output-template: %s", err)
+		}
 	}
 	if plugin.DryRun {
 		plugin.Verbose = true
@@ -369,9 +674,39 @@ func removeDuplicates(elements []string) []string { // change string to int here
 	return result
 }
 
+// compileFileExcludes compiles the --exclude-file-expr regexps.
+func compileFileExcludes() ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(plugin.ExcludeFileExprs))
+	for i, expr := range plugin.ExcludeFileExprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exclude-file-expr %s: %s", expr, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// walkDepth returns the depth of path under root, 1 being the entries of
+// root itself.
+func walkDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(os.PathSeparator)) + 1
+}
+
 func buildLogArray() ([]string, error) {
 	logs := []string{}
 	var e error
+	excludes, e := compileFileExcludes()
+	if e != nil {
+		return nil, e
+	}
+	// the files found by --log-file-expr and --log-glob, which --newest and
+	// --modified-within select from
+	var candidates []candidateFile
 	if plugin.LogFile != "" {
 		absPath, e := filepath.Abs(plugin.LogFile)
 		if e != nil {
@@ -395,18 +730,21 @@ func buildLogArray() ([]string, error) {
 		}
 
 		if filepath.IsAbs(absLogPath) {
-			// Collect all matching files with their modification times
-			type fileWithMtime struct {
-				path  string
-				mtime time.Time
+			walk := filepath.Walk
+			if plugin.FollowSymlinks {
+				walk = walkFollowingSymlinks
 			}
-			var matchingFiles []fileWithMtime
-
-			e = filepath.Walk(absLogPath, func(path string, info os.FileInfo, err error) error {
-				if err == nil && logRegExp.MatchString(path) {
+			e = walk(absLogPath, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.IsDir() && path != absLogPath {
+					// prune excluded directories, and those holding files beyond --max-depth
+					if excluded(excludes, []byte(path)) || (plugin.MaxDepth > 0 && walkDepth(absLogPath, path) >= plugin.MaxDepth) {
+						return filepath.SkipDir
+					}
+				}
+				if err == nil && logRegExp.MatchString(path) && !excluded(excludes, []byte(path)) {
 					if filepath.IsAbs(path) {
 						if !info.IsDir() {
-							matchingFiles = append(matchingFiles, fileWithMtime{
+							candidates = append(candidates, candidateFile{
 								path:  path,
 								mtime: info.ModTime(),
 							})
@@ -420,26 +758,37 @@ func buildLogArray() ([]string, error) {
 			if e != nil {
 				return nil, e
 			}
-
-			// If mtime flag is set, only keep the file with the most recent modification time
-			if plugin.UseLatestMtime && len(matchingFiles) > 1 {
-				// Sort by modification time, most recent first
-				sort.Slice(matchingFiles, func(i, j int) bool {
-					return matchingFiles[i].mtime.After(matchingFiles[j].mtime)
-				})
-				// Keep only the most recently modified file
-				logs = append(logs, matchingFiles[0].path)
-				if plugin.Verbose {
-					fmt.Printf("Using latest modified file: %s (mtime: %v)\n", matchingFiles[0].path, matchingFiles[0].mtime)
-				}
-			} else {
-				// Add all matching files
-				for _, file := range matchingFiles {
-					logs = append(logs, file.path)
-				}
+		}
+	}
+	for _, pattern := range plugin.LogGlobs {
+		matches, e := expandGlob(pattern, excludes)
+		if e != nil {
+			return nil, fmt.Errorf("invalid --log-glob %s: %s", pattern, e)
+		}
+		for _, path := range matches {
+			file := candidateFile{path: path}
+			if info, err := os.Stat(path); err == nil {
+				file.mtime = info.ModTime()
 			}
+			candidates = append(candidates, file)
+		}
+	}
+
+	// --mtime keeps only the most recently modified file
+	newest := plugin.Newest
+	if plugin.UseLatestMtime {
+		newest = 1
+	}
+	var modifiedWithin time.Duration
+	if plugin.ModifiedWithin != "" {
+		if modifiedWithin, e = parsePositiveDuration(plugin.ModifiedWithin); e != nil {
+			return nil, fmt.Errorf("invalid --modified-within: %s", e)
 		}
 	}
+	logs = append(logs, selectFiles(candidates, modifiedWithin, newest, time.Now())...)
+	if plugin.FollowSymlinks {
+		logs = resolveSymlinks(logs)
+	}
 	logs = removeDuplicates(logs)
 	if plugin.Verbose {
 		fmt.Printf("Log file array to process: %v\n", logs)
@@ -447,9 +796,36 @@ func buildLogArray() ([]string, error) {
 	return logs, e
 }
 
-func processLogFile(file string, enc *json.Encoder) (int, error) {
+// defaultRecordTimeout is how long the file must go unmodified for its final
+// multiline record to be read, when --partial-line-timeout isn't set.
+const defaultRecordTimeout = 30 * time.Second
+
+// multilineContinuation returns the Analyzer.Continuation func for the
+// configured multiline options, or nil if multiline records are not in use.
+func multilineContinuation() (func([]byte) bool, error) {
+	if plugin.MultilineStart != "" {
+		re, err := regexp.Compile(plugin.MultilineStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --multiline-start-expr: %s", err)
+		}
+		return func(line []byte) bool {
+			return !re.Match(line)
+		}, nil
+	}
+	if plugin.MultilineContinue != "" {
+		re, err := regexp.Compile(plugin.MultilineContinue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --multiline-continue-expr: %s", err)
+		}
+		return re.Match, nil
+	}
+	return nil, nil
+}
+
+func processLogFile(file string, enc resultEncoder) (FileReport, error) {
+	report := FileReport{Path: file, PatternMatches: map[string]int{}}
 	if !filepath.IsAbs(file) {
-		return 0, fmt.Errorf("error file %s: is not absolute path", file)
+		return report, fmt.Errorf("error file %s: is not absolute path", file)
 	}
 	if plugin.Verbose {
 		fmt.Printf("Now Processing: %v\n", file)
@@ -457,9 +833,9 @@ func processLogFile(file string, enc *json.Encoder) (int, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		if plugin.MissingOK {
-			return 0, nil
+			return report, nil
 		} else {
-			return 0, fmt.Errorf("error couldn't open log file %s: %s", file, err)
+			return report, fmt.Errorf("error couldn't open log file %s: %s", file, err)
 		}
 
 	}
@@ -469,17 +845,49 @@ func processLogFile(file string, enc *json.Encoder) (int, error) {
 		}
 	}()
 
-	stateFile := filepath.Join(plugin.StateDir, strings.ReplaceAll(file, string(os.PathSeparator), string("_")))
+	patterns, err := buildPatterns()
+	if err != nil {
+		return report, err
+	}
+	fingerprint := matchFingerprint(patterns)
+	stateFile := stateFilePath(file, fingerprint)
 	if plugin.Verbose {
 		fmt.Printf("stateFile: %s\n", stateFile)
 	}
+	lock, err := lockState(stateFile)
+	if errors.Is(err, errLocked) && plugin.LockContention != "wait" && plugin.LockContention != "fail" {
+		if plugin.Verbose {
+			fmt.Printf("Skipping %s, as another run of the check is processing it\n", file)
+		}
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("error couldn't lock state for log file %s: %w", file, err)
+	}
+	defer func() {
+		if err := unlockState(lock); err != nil {
+			fmt.Printf("error couldn't unlock state for log file %s: %s\n", file, err)
+		}
+	}()
+	if err := adoptLegacyState(file, stateFile, fingerprint); err != nil {
+		return report, fmt.Errorf("error couldn't rename state file for log file %s: %s", file, err)
+	}
+	continuation, err := multilineContinuation()
+	if err != nil {
+		return report, err
+	}
 	state, err := getState(stateFile)
+	// a corrupt state file is moved aside, resuming from --corrupt-state
+	skipToEnd := false
+	if errors.Is(err, errCorruptState) {
+		skipToEnd, err = recoverState(stateFile, err)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("error couldn't get state for log file %s: %s", file, err)
+		return report, fmt.Errorf("error couldn't get state for log file %s: %s", file, err)
 
 	}
 	resetState := false
-	if state.MatchExpr != "" && state.MatchExpr != plugin.MatchExpr {
+	if state.MatchExpr != "" && state.MatchExpr != fingerprint {
 		resetState = true
 	}
 	if resetState {
@@ -489,22 +897,56 @@ func processLogFile(file string, enc *json.Encoder) (int, error) {
 				fmt.Printf("info: resetting state file %s because unexpected cached matching condition detected and --reset-state in use\n", file)
 			}
 		} else {
-			return 0, fmt.Errorf("error: state file for %s has unexpected cached matching condition:: Expr: '%s'. Either use --reset-state option, or manually delete state file '%s'", file, state.MatchExpr, stateFile)
+			return report, fmt.Errorf("error: state file for %s has unexpected cached matching condition:: Expr: '%s'. Either use --reset-state option, or manually delete state file '%s'", file, state.MatchExpr, stateFile)
 		}
 	}
 
+	firstRun := state.empty()
+	now := time.Now()
+	startHeartbeats(&state, stateFile, patterns, now)
+	report.LastSeen = state.LastSeen
+	// saveState records this run in the state, and writes it
+	saveState := func() error {
+		state.recordRun(report.Matches, now)
+		return setState(state, stateFile)
+	}
+
 	info, err := f.Stat()
 	if err != nil {
-		return 0, fmt.Errorf("error couldn't get info for file %s: %s", file, err)
+		return report, fmt.Errorf("error couldn't get info for file %s: %s", file, err)
+	}
+	// a file not seen before last grew when it was last modified
+	if state.LastGrowth.IsZero() {
+		state.LastGrowth = info.ModTime()
+	}
+	report.LastGrowth = state.LastGrowth
+	compression, err := detectCompression(f)
+	if err != nil {
+		return report, fmt.Errorf("error couldn't read log file %s: %s", file, err)
 	}
 	// supress alerts on first run (when state file is empty) only when configured (with -ignore-initial-run)
-	if state == (State{}) && plugin.IgnoreInitialRun {
+	if (firstRun && plugin.IgnoreInitialRun) || skipToEnd {
 		state.Offset = int64(info.Size())
-		state.MatchExpr = plugin.MatchExpr
-		if err := setState(state, stateFile); err != nil {
-			return 0, fmt.Errorf("error couldn't set state for log file %s: %s", file, err)
+		// a final line still being written is read on the next run
+		if compression == "" && holdPartialLine(info, now) {
+			if state.Offset, err = lastLineEnd(f, info.Size()); err != nil {
+				return report, fmt.Errorf("error couldn't read log file %s: %s", file, err)
+			}
+		}
+		if compression != "" {
+			if state.Offset, err = decompressedSize(f, compression); err != nil {
+				return report, fmt.Errorf("error couldn't decompress log file %s: %s", file, err)
+			}
+			state.CompressedSize = info.Size()
+		}
+		state.MatchExpr = fingerprint
+		if err := recordFile(f, info, &state); err != nil {
+			return report, fmt.Errorf("error couldn't read log file %s: %s", file, err)
 		}
-		return 0, nil
+		if err := saveState(); err != nil {
+			return report, fmt.Errorf("error couldn't set state for log file %s: %s", file, err)
+		}
+		return report, nil
 	}
 
 	offset := state.Offset
@@ -514,88 +956,260 @@ func processLogFile(file string, enc *json.Encoder) (int, error) {
 	// Are we looking at freshly rotated file since last time we run?
 	// If so let's reset the offset back to 0 and read the file again
 	if offset < 0 {
-		return 0, fmt.Errorf("error file %s: cached offset is less than 0, possibly corrupt state file: %s", file, stateFile)
+		return report, fmt.Errorf("error file %s: cached offset is less than 0, possibly corrupt state file: %s", file, stateFile)
 	}
-	if offset > info.Size() {
-		if plugin.Verbose {
-			fmt.Printf("Resetting offset to zero, because cached offset (%v bytes) is beyond end of file (%v bytes), indicating file has been rotated, truncated or replaced\n", offset, info.Size())
+	var reader io.Reader = f
+	if compression != "" {
+		if offset > 0 && fileReplaced(f, info, state) {
+			if plugin.Verbose {
+				fmt.Printf("Resetting offset to zero, because %s is not the file read previously, indicating file has been replaced\n", file)
+			}
+			offset = 0
 		}
-		offset = 0
-	}
-
-	if offset > 0 {
-		if offset == info.Size() {
+		if offset > 0 && state.CompressedSize == info.Size() {
 			if plugin.Verbose {
-				fmt.Printf("Cached offset in state directory for %s indicates file not updated since last read\n", file)
+				fmt.Printf("Cached offset in state directory for %s indicates compressed file already read\n", file)
 			}
-			return 0, nil
-		} else {
-			if _, err := f.Seek(offset, io.SeekStart); err != nil {
-				return 0, fmt.Errorf("error couldn't seek file %s to offset %d: %s", file, offset, err)
+			if err := saveState(); err != nil {
+				return report, fmt.Errorf("error setting state: %s", err)
+			}
+			return report, nil
+		}
+		r, from, err := openDecompressed(f, compression, offset)
+		if err != nil {
+			return report, fmt.Errorf("error couldn't decompress log file %s: %s", file, err)
+		}
+		defer r.Close()
+		if from != offset && plugin.Verbose {
+			fmt.Printf("Resetting offset to zero, because cached offset (%v bytes) is beyond end of decompressed file, indicating file has been replaced\n", offset)
+		}
+		offset = from
+		reader = r
+	} else {
+		rotated := false
+		if offset > info.Size() {
+			if plugin.Verbose {
+				fmt.Printf("Resetting offset to zero, because cached offset (%v bytes) is beyond end of file (%v bytes), indicating file has been rotated, truncated or replaced\n", offset, info.Size())
+			}
+			rotated = true
+		} else if offset > 0 && fileReplaced(f, info, state) {
+			if plugin.Verbose {
+				fmt.Printf("Resetting offset to zero, because %s is not the file read previously, indicating file has been rotated or replaced\n", file)
+			}
+			rotated = true
+		}
+		if rotated {
+			// finish reading the lines written to the file before it was rotated
+			if predecessor := findPredecessor(file, state); predecessor != "" {
+				if plugin.Verbose {
+					fmt.Printf("Reading %s from cached offset %v, as the file %s was rotated to\n", predecessor, offset, file)
+				}
+				if err := analyzePredecessor(predecessor, offset, patterns, continuation, enc, &report, &state, now); err != nil {
+					return report, err
+				}
+			}
+			offset = 0
+		}
 
+		if offset > 0 {
+			if offset == info.Size() {
+				if plugin.Verbose {
+					fmt.Printf("Cached offset in state directory for %s indicates file not updated since last read\n", file)
+				}
+				// state files written before file identities were recorded
+				if state.HeadHash == nil {
+					if err := recordFile(f, info, &state); err != nil {
+						return report, fmt.Errorf("error couldn't read log file %s: %s", file, err)
+					}
+				}
+				if err := saveState(); err != nil {
+					return report, fmt.Errorf("error setting state: %s", err)
+				}
+				return report, nil
+			} else {
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					return report, fmt.Errorf("error couldn't seek file %s to offset %d: %s", file, offset, err)
+
+				}
 			}
 		}
 	}
 
-	var reader io.Reader = f
-	if plugin.MaxBytes > 0 {
-		reader = io.LimitReader(f, plugin.MaxBytes)
+	// a compressed file is complete, while a plain one may be written to
+	holdPartial := compression == "" && holdPartialLine(info, now)
+	holdRecord := compression == "" && holdFinalRecord(info, now)
+	bytesRead, truncated, status, err := analyzeLog(file, reader, offset, patterns, continuation, holdPartial, holdRecord, enc, &report, &state, now)
+	if err != nil {
+		return report, err
+	}
+	if plugin.Verbose {
+		fmt.Printf("File %s Match Count: %v\n", file, report.Matches)
+	}
+	state.Offset = int64(offset + bytesRead)
+	state.MatchExpr = fingerprint
+	if bytesRead > 0 || info.Size() > state.Size {
+		state.LastGrowth = info.ModTime()
+		report.LastGrowth = state.LastGrowth
+	}
+	state.Size = info.Size()
+	if err := recordFile(f, info, &state); err != nil {
+		return report, fmt.Errorf("error couldn't read log file %s: %s", file, err)
+	}
+	state.CompressedSize = 0
+	if compression != "" && !truncated {
+		state.CompressedSize = info.Size()
+	}
+	previousBacklog := state.Backlog
+	state.Backlog = 0
+	if truncated && compression == "" {
+		state.Backlog = info.Size() - state.Offset
+	}
+	report.Backlog = state.Backlog
+	report.FallingBehind = previousBacklog > 0 && state.Backlog >= previousBacklog
+	if plugin.Verbose {
+		fmt.Printf("File %s Match Status %v BytesRead: %v"+
+			" New Offset: %v\n", file, status, bytesRead, state.Offset)
 	}
 
+	if err := saveState(); err != nil {
+		return report, fmt.Errorf("error setting state: %s", err)
+	}
+	return report, nil
+}
+
+// analyzePredecessor reads the file a log file was rotated to, from the
+// offset read before rotation to its end. The file is no longer written to,
+// so a final line without a trailing newline is read too.
+func analyzePredecessor(path string, offset int64, patterns []MatchPattern, continuation func([]byte) bool, enc resultEncoder, report *FileReport, state *State, now time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error couldn't open rotated log file %s: %s", path, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("error couldn't close rotated log file %s: %s\n", path, err)
+		}
+	}()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error couldn't seek file %s to offset %d: %s", path, offset, err)
+	}
+	_, _, _, err = analyzeLog(path, f, offset, patterns, continuation, false, false, enc, report, state, now)
+	return err
+}
+
+// analyzeLog reads r, positioned at offset in the file at path, and encodes
+// each matching line, counting it in the report and recording when heartbeat
+// patterns were seen in the state. It returns the number of bytes read, which
+// leaves out a final line without a trailing newline when holdPartial is set,
+// and a final multiline record when holdRecord is set, and whether reading
+// stopped at --max-bytes before the end of r.
+func analyzeLog(path string, r io.Reader, offset int64, patterns []MatchPattern, continuation func([]byte) bool, holdPartial bool, holdRecord bool, enc resultEncoder, report *FileReport, state *State, now time.Time) (int64, bool, int, error) {
 	analyzer := Analyzer{
-		Path:           file,
-		Procs:          plugin.Procs,
-		Log:            reader,
-		Offset:         offset,
-		Func:           AnalyzeRegexp(plugin.MatchExpr),
-		VerboseResults: plugin.VerboseResults,
+		Path:            path,
+		Procs:           plugin.Procs,
+		Log:             r,
+		Offset:          offset,
+		Func:            buildAnalyzerFunc(patterns),
+		VerboseResults:  plugin.VerboseResults,
+		KeepFields:      checkNameUsesFields(plugin.CheckNameTemplate) || plugin.GroupBy != "",
+		Continuation:    continuation,
+		HoldPartialLine: holdPartial,
+		HoldRecord:      holdRecord,
+		MaxBytes:        plugin.MaxBytes,
 	}
 
 	status := sensu.CheckStateOK
 	results := analyzer.Go(context.Background())
-	numResults := 0
 	for result := range results {
 		if result.Err != nil {
 			status = sensu.CheckStateCritical
 		}
 		if err := enc.Encode(result); err != nil {
-			return 0, fmt.Errorf("error couldn't encode result %+v for file %s: %s", result, result.Path, err)
+			return analyzer.BytesRead(), analyzer.Truncated(), status, fmt.Errorf("error couldn't encode result %+v for file %s: %s", result, result.Path, err)
+		}
+		report.add(result)
+		for _, name := range result.Patterns {
+			if _, ok := state.LastSeen[name]; ok {
+				state.LastSeen[name] = now
+			}
 		}
-		numResults++
 	}
-	if plugin.Verbose {
-		fmt.Printf("File %s Match Count: %v\n", file, numResults)
+	if err := analyzer.Err(); err != nil {
+		return analyzer.BytesRead(), analyzer.Truncated(), status, fmt.Errorf("error couldn't read log file %s: %s", path, err)
 	}
-	bytesRead := analyzer.BytesRead()
-	state.Offset = int64(offset + bytesRead)
-	state.MatchExpr = plugin.MatchExpr
-	if plugin.Verbose {
-		fmt.Printf("File %s Match Status %v BytesRead: %v"+
-			" New Offset: %v\n", file, status, bytesRead, state.Offset)
+	return analyzer.BytesRead(), analyzer.Truncated(), status, nil
+}
+
+// holdPartialLine reports whether a final line without a trailing newline
+// should be left for the next run, as it may still be being written. With
+// --partial-line-timeout, the line is read once the file hasn't been
+// modified for that long.
+func holdPartialLine(info os.FileInfo, now time.Time) bool {
+	if plugin.PartialLineTimeout == "" {
+		return true
+	}
+	timeout, err := parsePositiveDuration(plugin.PartialLineTimeout)
+	return err != nil || now.Sub(info.ModTime()) < timeout
+}
+
+// lastLineEnd returns the offset just past the last newline in the first size
+// bytes of f, or 0 if there is none.
+func lastLineEnd(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
 	}
+	return 0, nil
+}
 
-	if err := setState(state, stateFile); err != nil {
-		return 0, fmt.Errorf("error setting state: %s", err)
+// holdFinalRecord reports whether the final multiline record should be left
+// for the next run, as more of its lines may still be written. Unlike a
+// partial line, the last record of a file is complete more often than not,
+// such as a stack trace written just before an application exited, so it's
+// read once the file hasn't been modified for --partial-line-timeout, or
+// defaultRecordTimeout.
+func holdFinalRecord(info os.FileInfo, now time.Time) bool {
+	timeout := defaultRecordTimeout
+	if plugin.PartialLineTimeout != "" {
+		var err error
+		if timeout, err = parsePositiveDuration(plugin.PartialLineTimeout); err != nil {
+			return true
+		}
 	}
-	return numResults, nil
+	return now.Sub(info.ModTime()) < timeout
 }
 
 func setStatus(currentStatus int, numMatches int) int {
+	return setThresholdStatus(currentStatus, numMatches, plugin.WarningThreshold, plugin.CriticalThreshold)
+}
+
+func setThresholdStatus(currentStatus int, numMatches int, warningThreshold int, criticalThreshold int) int {
 	status := sensu.CheckStateOK
 	warn := false
 	critical := false
 	if plugin.InvertThresholds {
-		if plugin.WarningThreshold > 0 && numMatches <= plugin.WarningThreshold {
+		if warningThreshold > 0 && numMatches <= warningThreshold {
 			warn = true
 		}
-		if plugin.CriticalThreshold > 0 && numMatches <= plugin.CriticalThreshold {
+		if criticalThreshold > 0 && numMatches <= criticalThreshold {
 			critical = true
 		}
 	} else {
-		if plugin.WarningThreshold > 0 && numMatches >= plugin.WarningThreshold {
+		if warningThreshold > 0 && numMatches >= warningThreshold {
 			warn = true
 		}
-		if plugin.CriticalThreshold > 0 && numMatches >= plugin.CriticalThreshold {
+		if criticalThreshold > 0 && numMatches >= criticalThreshold {
 			critical = true
 		}
 	}
@@ -622,6 +1236,69 @@ func setStatus(currentStatus int, numMatches int) int {
 	}
 }
 
+// setPatternsStatus holds the matches of each pattern to the thresholds of
+// that pattern.
+func setPatternsStatus(currentStatus int, patternMatches map[string]int, patterns []MatchPattern) int {
+	for _, p := range patterns {
+		// heartbeat patterns alert when absent rather than on matches
+		if p.AbsentFor > 0 {
+			continue
+		}
+		currentStatus = setThresholdStatus(currentStatus, patternMatches[p.Name], p.WarningThreshold, p.CriticalThreshold)
+	}
+	return currentStatus
+}
+
+// summaryOutput returns the number of matching lines in each file, and for
+// each pattern when there are several, followed by the top groups when
+// --group-by is used.
+func summaryOutput(files map[string]FileReport, patterns []MatchPattern) string {
+	output := ""
+	for f, report := range files {
+		output = output + fmt.Sprintf("File %s has %d matching lines\n", f, report.Matches)
+		if len(patterns) > 1 {
+			for _, p := range patterns {
+				output = output + fmt.Sprintf("  Pattern %s has %d matching lines\n", p.Name, report.PatternMatches[p.Name])
+			}
+		}
+	}
+	if plugin.GroupBy != "" {
+		output = output + groupsOutput(files)
+	}
+	return output
+}
+
+// generateEvent creates an event with the given status and output and sends
+// it to the agent events API, or reports it when --dry-run is used. The
+// returned status is a warning if the event couldn't be generated.
+func generateEvent(event *corev2.Event, status int, fields map[string]string, output string, metrics *corev2.Metrics) int {
+	if event == nil {
+		fmt.Printf("Error: Input event not defined. Event generation aborted\n")
+		return sensu.CheckStateWarning
+	}
+	if len(plugin.EventsAPI) == 0 {
+		fmt.Printf("Error: Event API url not defined. Event generation aborted\n")
+		return sensu.CheckStateWarning
+	}
+	outputEvent, err := createEvent(event, status, plugin.CheckNameTemplate, fields, output)
+	if err != nil {
+		fmt.Printf("Error creating event: %s\n", err)
+		return sensu.CheckStateWarning
+	}
+	outputEvent.Metrics = metrics
+
+	// if --dry-run selected lets report what we would have sent instead of sending.
+	if plugin.DryRun {
+		fmt.Printf("Dry-run enabled, event to send:\n%+v\n", outputEvent)
+	} else {
+		if err := sendEvent(plugin.EventsAPI, outputEvent); err != nil {
+			fmt.Printf("Error sending event: %s\n", err)
+			return sensu.CheckStateWarning
+		}
+	}
+	return sensu.CheckStateOK
+}
+
 func executeCheck(event *corev2.Event) (int, error) {
 	var status int
 	status = 0
@@ -642,71 +1319,88 @@ func executeCheck(event *corev2.Event) (int, error) {
 	if e != nil {
 		return sensu.CheckStateCritical, e
 	}
-	fileErrors := []error{}
-	matchingFiles := make(map[string]int)
-	eventBuf := new(bytes.Buffer)
-	enc := json.NewEncoder(eventBuf)
-
-	for _, file := range logs {
-		numMatches, err := processLogFile(file, enc)
-		if numMatches >= 0 {
-			matchingFiles[file] = numMatches
-		}
-		if err != nil {
-			fileErrors = append(fileErrors, err)
-			status = sensu.CheckStateOK
-			continue
+	patterns, e := buildPatterns()
+	if e != nil {
+		return sensu.CheckStateCritical, e
+	}
+	maxFileAge := time.Duration(0)
+	if plugin.MaxFileAge != "" {
+		if maxFileAge, e = parsePositiveDuration(plugin.MaxFileAge); e != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("invalid --max-file-age: %s", e)
 		}
-		status = setStatus(status, numMatches)
+	}
+	eventBuf := new(bytes.Buffer)
+	enc, e := newResultEncoder(eventBuf)
+	if e != nil {
+		return sensu.CheckStateCritical, e
+	}
+	// when the check name depends on the fields of matching lines, matches
+	// are routed to an event per check name
+	var router *eventRouter
+	if !plugin.DisableEvent && event != nil && checkNameUsesFields(plugin.CheckNameTemplate) {
+		router = newEventRouter(event, plugin.CheckNameTemplate)
+		enc = router
+	}
 
-	} // end of loop over log files
+	matchingFiles, fileErrors := processLogFiles(logs, enc)
 	if len(fileErrors) > 0 {
+		// another run of the check holding the lock isn't a problem with
+		// the log files
+		status := sensu.CheckStateUnknown
 		for _, e := range fileErrors {
 			fmt.Printf("%v\n", e)
+			if !errors.Is(e, errLocked) {
+				status = sensu.CheckStateCritical
+			}
 		}
-		return sensu.CheckStateCritical, nil
+		return status, nil
 	}
+	// each pattern is held to its own thresholds
+	status = reportsStatus(status, matchingFiles, patterns)
+	now := time.Now()
+	absent := absentPatterns(matchingFiles, patterns, now)
+	stale := staleFiles(matchingFiles, maxFileAge, now)
+	silent := len(absent) > 0 || len(stale) > 0
+	behind := backlogFiles(matchingFiles)
+	if router != nil {
+		// a failure to send one event doesn't hold back the others, and
+		// the check reports the worst status of sending them
+		sendStatus := sensu.CheckStateOK
+		for _, group := range router.Groups() {
+			groupStatus := reportsStatus(sensu.CheckStateOK, group.Files, patterns)
+			if groupStatus == sensu.CheckStateOK {
+				continue
+			}
+			output := summaryOutput(group.Files, patterns)
+			if plugin.VerboseResults {
+				output = fmt.Sprintf("%s\n", group.buf.String())
+			}
+			if status := generateEvent(event, groupStatus, group.Fields, output, nil); status > sendStatus {
+				sendStatus = status
+			}
+		}
+		if silent || len(behind) > 0 {
+			if status := generateEvent(event, backlogStatus(silenceStatus(sensu.CheckStateOK, silent), behind), nil, heartbeatOutput(absent, now)+staleOutput(stale, now)+backlogOutput(behind), backlogMetrics(matchingFiles, now)); status > sendStatus {
+				sendStatus = status
+			}
+		}
+		return sendStatus, nil
+	}
+	status = backlogStatus(silenceStatus(status, silent), behind)
 	// sendEvent or report to stdout
 	if status != sensu.CheckStateOK {
 		//use summary output unless VerboseResults is true
-		output := ""
+		output := summaryOutput(matchingFiles, patterns)
 		if plugin.VerboseResults {
 			output = fmt.Sprintf("%s\n", eventBuf.String())
-		} else {
-			for f, n := range matchingFiles {
-				output = output + fmt.Sprintf("File %s has %d matching lines\n", f, n)
-			}
 		}
+		output = output + heartbeatOutput(absent, now) + staleOutput(stale, now) + backlogOutput(behind)
 		//if event generation disabled just output the results as this check's output
 		if plugin.DisableEvent {
 			fmt.Printf("%s", output)
 			return status, nil
 		}
-
-		// proceed with event generation
-		if event == nil {
-			fmt.Printf("Error: Input event not defined. Event generation aborted\n")
-			return sensu.CheckStateWarning, nil
-		}
-		if len(plugin.EventsAPI) == 0 {
-			fmt.Printf("Error: Event API url not defined. Event generation aborted\n")
-			return sensu.CheckStateWarning, nil
-		}
-		outputEvent, err := createEvent(event, status, plugin.CheckNameTemplate, output)
-		if err != nil {
-			fmt.Printf("Error creating event: %s\n", err)
-			return sensu.CheckStateWarning, nil
-		}
-
-		// if --dry-run selected lets report what we would have sent instead of sending.
-		if plugin.DryRun {
-			fmt.Printf("Dry-run enabled, event to send:\n%+v\n", outputEvent)
-		} else {
-			if err := sendEvent(plugin.EventsAPI, outputEvent); err != nil {
-				fmt.Printf("Error sending event: %s\n", err)
-				return sensu.CheckStateWarning, nil
-			}
-		}
+		return generateEvent(event, status, nil, output, backlogMetrics(matchingFiles, now)), nil
 	}
 
 	return sensu.CheckStateOK, nil
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,8 +32,33 @@ func (h apiHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func clearPlugin() {
 	plugin.LogFile = ""
+	plugin.LogGlobs = nil
+	plugin.ExcludeFileExprs = nil
+	plugin.MaxDepth = 0
+	plugin.FollowSymlinks = false
+	plugin.Newest = 0
+	plugin.ModifiedWithin = ""
+	plugin.FileWorkers = 1
+	plugin.PartialLineTimeout = ""
+	plugin.CorruptState = ""
+	plugin.LockContention = ""
+	plugin.LockTimeout = ""
 	plugin.StateDir = ""
 	plugin.MatchExpr = ""
+	plugin.MatchPatterns = nil
+	plugin.ExcludeExprs = nil
+	plugin.MultilineStart = ""
+	plugin.MultilineContinue = ""
+	plugin.VerboseResults = false
+	plugin.LogFormat = ""
+	plugin.FallbackExpr = ""
+	plugin.OutputTemplate = ""
+	plugin.Grok = false
+	plugin.GrokPatternsDir = ""
+	plugin.GroupBy = ""
+	plugin.TopGroups = 0
+	plugin.AbsentFor = ""
+	plugin.MaxFileAge = ""
 	plugin.LogFileExpr = ""
 	plugin.LogPath = ""
 	plugin.StateDir = ""
@@ -43,6 +69,8 @@ func clearPlugin() {
 	plugin.CriticalOnly = false
 	plugin.WarningThreshold = 0
 	plugin.CriticalThreshold = 0
+	plugin.EnableStateReset = false
+	plugin.MaxBytes = 0
 }
 
 func TestStdin(t *testing.T) {
@@ -450,6 +478,95 @@ func TestExecuteWithEvent(t *testing.T) {
 
 }
 
+func TestExecuteWithEventPerCapture(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	event := corev2.FixtureEvent("foo", "bar")
+	var mu sync.Mutex
+	received := map[string]*corev2.Event{}
+	failing := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outputEvent := &corev2.Event{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(outputEvent))
+		mu.Lock()
+		defer mu.Unlock()
+		if outputEvent.Check.Name == failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		received[outputEvent.Check.Name] = outputEvent
+	}))
+	defer server.Close()
+
+	plugin.Procs = 1
+	plugin.EventsAPI = server.URL + "/events"
+	defer func(checkNameTemplate string) {
+		plugin.CheckNameTemplate = checkNameTemplate
+	}(plugin.CheckNameTemplate)
+	plugin.CheckNameTemplate = "{{ .Check.Name }}-{{ .Fields.user }}"
+	plugin.MatchExpr = `failed login user=(?P<user>\w+)`
+	plugin.WarningThreshold = 2
+	plugin.CriticalThreshold = 3
+	plugin.VerboseResults = true
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "auth.log")
+	plugin.StateDir = t.TempDir()
+	content := "failed login user=alice\n" +
+		"failed login user=bob\n" +
+		"failed login user=alice\n" +
+		"login user=carol\n" +
+		"failed login user=alice\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+
+	status, err := executeCheck(event)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	// bob is below the warning threshold
+	assert.Len(t, received, 1)
+	if alice, ok := received["bar-alice"]; assert.True(t, ok) {
+		assert.Equal(t, uint32(2), alice.Check.Status)
+		assert.Equal(t, 3, strings.Count(alice.Check.Output, `"user":"alice"`))
+	}
+
+	// each group over its threshold gets its own event, even when sending
+	// another one fails
+	content = "failed login user=alice\n" +
+		"failed login user=bob\n" +
+		"failed login user=alice\n" +
+		"failed login user=bob\n"
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(content)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	received = map[string]*corev2.Event{}
+	failing = "bar-alice"
+	status, err = executeCheck(event)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+	assert.Len(t, received, 1)
+	if bob, ok := received["bar-bob"]; assert.True(t, ok) {
+		assert.Equal(t, uint32(1), bob.Check.Status)
+		assert.Equal(t, 2, strings.Count(bob.Check.Output, `"user":"bob"`))
+	}
+	assert.Equal(t, "bar", event.Check.Name)
+
+	// a pattern without the capture group isn't named after the check
+	plugin.MatchPatterns = []string{"name=oom,warning=1,critical=5,expr=OOM"}
+	plugin.LogFile = filepath.Join(logdir, "kern.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("OOM killed process\n"), 0644))
+	received = map[string]*corev2.Event{}
+	failing = ""
+	status, err = executeCheck(event)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.NotContains(t, received, "bar")
+	if oom, ok := received["bar-"]; assert.True(t, ok) {
+		assert.Equal(t, uint32(1), oom.Check.Status)
+	}
+}
+
 func TestProcessLogFile(t *testing.T) {
 	plugin.Verbose = true
 	plugin.MaxBytes = 4000
@@ -476,23 +593,23 @@ func TestProcessLogFile(t *testing.T) {
 	plugin.WarningOnly = true
 	logs, err := buildLogArray()
 	assert.NoError(t, err)
-	matches, err := processLogFile(logs[0], enc)
+	report, err := processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	// test for abs log file path err
 	td, err = os.MkdirTemp("", "")
 	defer os.RemoveAll(td)
 	assert.NoError(t, err)
 	plugin.StateDir = td
-	matches, err = processLogFile(plugin.LogFile, enc)
+	report, err = processLogFile(plugin.LogFile, enc)
 	assert.Error(t, err)
-	assert.Equal(t, 0, matches)
+	assert.Equal(t, 0, report.Matches)
 	logs, err = buildLogArray()
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	// test for IgnoreFirstRun
 	plugin.IgnoreInitialRun = true
@@ -502,9 +619,9 @@ func TestProcessLogFile(t *testing.T) {
 	plugin.StateDir = td
 	logs, err = buildLogArray()
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, matches)
+	assert.Equal(t, 0, report.Matches)
 	plugin.IgnoreInitialRun = false
 	td, err = os.MkdirTemp("", "")
 	defer os.RemoveAll(td)
@@ -512,37 +629,33 @@ func TestProcessLogFile(t *testing.T) {
 	plugin.StateDir = td
 	logs, err = buildLogArray()
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
-	// test for state mismatch error
+	// another match expression keeps its own state
 	plugin.MatchExpr = "hmm"
-	matches, err = processLogFile(logs[0], enc)
-	assert.Error(t, err)
-	assert.Equal(t, 0, matches)
-	plugin.EnableStateReset = true
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, matches)
+	assert.Equal(t, 0, report.Matches)
 
 	// Do not run error condition tests that require chmod on windows, they will fail
 	if runtime.GOOS != "windows" {
 		// test for read log file error
 		err = os.Chmod("./testingdata/test.log", 0000)
 		assert.NoError(t, err)
-		matches, err = processLogFile(logs[0], enc)
+		report, err = processLogFile(logs[0], enc)
 		assert.Error(t, err)
-		assert.Equal(t, 0, matches)
+		assert.Equal(t, 0, report.Matches)
 		err = os.Chmod("./testingdata/test.log", 0755)
 		assert.NoError(t, err)
 
 		// test for state file read error
 		err = os.Chmod(td, 0000)
 		assert.NoError(t, err)
-		matches, err = processLogFile(logs[0], enc)
+		report, err = processLogFile(logs[0], enc)
 		assert.Error(t, err)
-		assert.Equal(t, 0, matches)
+		assert.Equal(t, 0, report.Matches)
 		err = os.Chmod(td, 0755)
 		assert.NoError(t, err)
 
@@ -553,9 +666,9 @@ func TestProcessLogFile(t *testing.T) {
 		plugin.StateDir = td
 		err = os.Chmod(td, 0500)
 		assert.NoError(t, err)
-		matches, err = processLogFile(logs[0], enc)
+		report, err = processLogFile(logs[0], enc)
 		assert.Error(t, err)
-		assert.Equal(t, 0, matches)
+		assert.Equal(t, 0, report.Matches)
 		err = os.Chmod(td, 0755)
 		assert.NoError(t, err)
 	}
@@ -592,14 +705,14 @@ func TestProcessLogFileRotatedFileVerboseTrue(t *testing.T) {
 
 	logs, err := buildLogArray()
 	assert.NoError(t, err)
-	matches, err := processLogFile(logs[0], enc)
+	report, err := processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	//re-run should have no new matches
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, matches)
+	assert.Equal(t, 0, report.Matches)
 
 	//rotate the file
 	os.Remove(plugin.LogFile)
@@ -613,9 +726,9 @@ func TestProcessLogFileRotatedFileVerboseTrue(t *testing.T) {
 	assert.NoError(t, err)
 	logs, err = buildLogArray()
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	//append file and test offset seeking
 	f, err = os.OpenFile(plugin.LogFile,
@@ -626,9 +739,9 @@ func TestProcessLogFileRotatedFileVerboseTrue(t *testing.T) {
 	f.Close()
 	_, err = os.ReadFile(plugin.LogFile)
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 }
 
@@ -663,14 +776,14 @@ func TestProcessLogFileRotatedFileVerboseFalse(t *testing.T) {
 
 	logs, err := buildLogArray()
 	assert.NoError(t, err)
-	matches, err := processLogFile(logs[0], enc)
+	report, err := processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	//re-run should have no new matches
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, matches)
+	assert.Equal(t, 0, report.Matches)
 
 	//rotate the file
 	os.Remove(plugin.LogFile)
@@ -684,9 +797,9 @@ func TestProcessLogFileRotatedFileVerboseFalse(t *testing.T) {
 	assert.NoError(t, err)
 	logs, err = buildLogArray()
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 	//append file and test offset seeking
 	f, err = os.OpenFile(plugin.LogFile,
@@ -697,9 +810,9 @@ func TestProcessLogFileRotatedFileVerboseFalse(t *testing.T) {
 	f.Close()
 	_, err = os.ReadFile(plugin.LogFile)
 	assert.NoError(t, err)
-	matches, err = processLogFile(logs[0], enc)
+	report, err = processLogFile(logs[0], enc)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, matches)
+	assert.Equal(t, 1, report.Matches)
 
 }
 
@@ -729,7 +842,7 @@ func TestProcessLogFileWithNegativeCachedOffset(t *testing.T) {
 		assert.NoError(t, err)
 		f.Close()
 
-		stateFile := filepath.Join(plugin.StateDir, strings.ReplaceAll(plugin.LogFile, string(os.PathSeparator), string("_")))
+		stateFile := testStateFile(t)
 		state, err := getState(stateFile)
 		assert.NoError(t, err)
 		state.Offset = -10
@@ -741,9 +854,9 @@ func TestProcessLogFileWithNegativeCachedOffset(t *testing.T) {
 
 		logs, err := buildLogArray()
 		assert.NoError(t, err)
-		matches, err := processLogFile(logs[0], enc)
+		report, err := processLogFile(logs[0], enc)
 		assert.Error(t, err)
-		assert.Equal(t, 0, matches)
+		assert.Equal(t, 0, report.Matches)
 	}
 }
 
@@ -787,3 +900,542 @@ func TestBuildLogArrayWithLatestMtime(t *testing.T) {
 	// The returned file should be the newer one (log2.log)
 	assert.Contains(t, logs[0], "log2.log")
 }
+
+func TestBuildLogArrayWithExcludesAndMaxDepth(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	td := t.TempDir()
+	for _, name := range []string{"app.log", "app.log.1.gz", "nginx/access.log", "journal/system.log", "pods/a/b/pod.log"} {
+		path := filepath.Join(td, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("test\n"), 0644))
+	}
+	plugin.LogPath = td
+	plugin.LogFileExpr = `\.log`
+	plugin.UseLatestMtime = false
+	plugin.ExcludeFileExprs = []string{`/journal$`, `\.gz$`}
+
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(td, "app.log"),
+		filepath.Join(td, "nginx", "access.log"),
+		filepath.Join(td, "pods", "a", "b", "pod.log"),
+	}, logs)
+
+	plugin.MaxDepth = 2
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(td, "app.log"),
+		filepath.Join(td, "nginx", "access.log"),
+	}, logs)
+
+	plugin.MaxDepth = 1
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(td, "app.log")}, logs)
+
+	plugin.MaxDepth = 0
+	plugin.LogFileExpr = ""
+	plugin.LogGlobs = []string{filepath.Join(td, "**", "*.log*")}
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(td, "app.log"),
+		filepath.Join(td, "nginx", "access.log"),
+		filepath.Join(td, "pods", "a", "b", "pod.log"),
+	}, logs)
+
+	plugin.ExcludeFileExprs = []string{`(`}
+	_, err = buildLogArray()
+	assert.Error(t, err)
+}
+
+func TestProcessLogFileWithMatchPatterns(t *testing.T) {
+	clearPlugin()
+	plugin.Verbose = false
+	plugin.Procs = 2
+	plugin.DisableEvent = true
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+	plugin.MatchPatterns = []string{
+		"name=error,expr=ERROR",
+		"name=fatal,warning=0,critical=1,expr=FATAL",
+	}
+
+	td, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(td)
+	plugin.StateDir = td
+
+	logdir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logdir)
+	plugin.LogFile = filepath.Join(logdir, "test.log")
+	err = os.WriteFile(plugin.LogFile, []byte("ERROR: one\nINFO: two\nERROR: three\n"), 0644)
+	assert.NoError(t, err)
+
+	eventBuf := new(bytes.Buffer)
+	enc := json.NewEncoder(eventBuf)
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	report, err := processLogFile(logs[0], enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	assert.Equal(t, 2, report.PatternMatches["error"])
+	assert.Equal(t, 0, report.PatternMatches["fatal"])
+
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("FATAL ERROR: four\n")
+	assert.NoError(t, err)
+	f.Close()
+
+	// a single FATAL line is critical even though the error pattern only warns
+	status, err := executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+
+	// changing the pattern set keeps its own state
+	plugin.MatchPatterns = []string{"name=error,expr=ERROR"}
+	report, err = processLogFile(logs[0], enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Matches)
+	clearPlugin()
+}
+
+func TestProcessLogFileWithMultiline(t *testing.T) {
+	clearPlugin()
+	plugin.Verbose = false
+	plugin.Procs = 2
+	plugin.DisableEvent = true
+	plugin.VerboseResults = true
+	plugin.MatchExpr = "Exception"
+
+	logdir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logdir)
+	plugin.LogFile = filepath.Join(logdir, "test.log")
+	content := "2024-01-01 INFO starting\n" +
+		"2024-01-01 ERROR request failed\n" +
+		"java.lang.IllegalStateException: boom\n" +
+		"\tat com.example.Foo.bar(Foo.java:10)\n" +
+		"\tat com.example.Foo.main(Foo.java:5)\n" +
+		"Caused by: java.io.IOException: closed\n" +
+		"2024-01-01 INFO recovered\n"
+	err = os.WriteFile(plugin.LogFile, []byte(content), 0644)
+	assert.NoError(t, err)
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+
+	// without multiline each line of the trace is counted separately
+	td, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(td)
+	plugin.StateDir = td
+	enc := json.NewEncoder(new(bytes.Buffer))
+	report, err := processLogFile(logs[0], enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+
+	for _, opts := range []struct{ start, cont string }{
+		{start: `^\d{4}-\d{2}-\d{2} `},
+		{cont: `^(\s|java\.|Caused by:)`},
+	} {
+		plugin.MultilineStart = opts.start
+		plugin.MultilineContinue = opts.cont
+		td, err := os.MkdirTemp("", "")
+		assert.NoError(t, err)
+		defer os.RemoveAll(td)
+		plugin.StateDir = td
+		eventBuf := new(bytes.Buffer)
+		enc := json.NewEncoder(eventBuf)
+		report, err := processLogFile(logs[0], enc)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Matches)
+		var result Result
+		assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+		assert.Equal(t, int64(len("2024-01-01 INFO starting\n")), result.Offset)
+		assert.Contains(t, result.Match, "Caused by: java.io.IOException")
+	}
+	clearPlugin()
+}
+
+func TestProcessLogFileWithMultilineHeldRecord(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.VerboseResults = true
+	plugin.MatchExpr = "Exception"
+	plugin.MultilineContinue = `^\s`
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	appendLog := func(s string) {
+		f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		_, err = f.WriteString(s)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	// the frames of the trace may not all be written yet
+	appendLog("INFO starting\nException\n  at com.a\n")
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	state, err := getState(testStateFile(t))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("INFO starting\n")), state.Offset)
+
+	// a new record completes the trace, which is counted once
+	appendLog("  at com.b\nINFO recovered\n")
+	eventBuf := new(bytes.Buffer)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	var result Result
+	assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+	assert.Equal(t, "Exception\n  at com.a\n  at com.b\n", result.Match)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+
+	// or the file stops changing for a while
+	appendLog("Exception\n  at com.d\n")
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	past := time.Now().Add(-defaultRecordTimeout - time.Second)
+	assert.NoError(t, os.Chtimes(plugin.LogFile, past, past))
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+
+	// for --partial-line-timeout, when set
+	appendLog("Exception\n  at com.c\n")
+	plugin.PartialLineTimeout = "1m"
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	past = time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(plugin.LogFile, past, past))
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+}
+
+func TestProcessLogFileWithJSONFormat(t *testing.T) {
+	clearPlugin()
+	plugin.Verbose = false
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+	plugin.LogFormat = "json"
+	plugin.MatchExpr = "level in (error,fatal)"
+	plugin.FallbackExpr = "^panic:"
+
+	td, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(td)
+	plugin.StateDir = td
+
+	logdir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logdir)
+	plugin.LogFile = filepath.Join(logdir, "test.log")
+	content := `{"level":"info","msg":"starting"}` + "\n" +
+		`{"msg":"write failed","level":"error"}` + "\n" +
+		"panic: nil map\n" +
+		`{"level":"fatal","msg":"exiting"}` + "\n"
+	err = os.WriteFile(plugin.LogFile, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	status, err := checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	report, err := processLogFile(logs[0], json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Matches)
+	assert.Equal(t, 2, report.PatternMatches[plugin.MatchExpr])
+	assert.Equal(t, 1, report.PatternMatches["fallback"])
+
+	plugin.MatchExpr = "level in (error"
+	_, err = checkArgs(nil)
+	assert.Error(t, err)
+	clearPlugin()
+}
+
+func TestProcessLogFileWithLogfmtFormat(t *testing.T) {
+	clearPlugin()
+	plugin.Verbose = false
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.LogFormat = "logfmt"
+	plugin.MatchExpr = "duration_ms>5000"
+
+	logdir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logdir)
+	plugin.LogFile = filepath.Join(logdir, "test.log")
+	content := "level=info msg=\"request done\" duration_ms=120\n" +
+		"level=warn msg=\"request done\" duration_ms=7300\n"
+	err = os.WriteFile(plugin.LogFile, []byte(content), 0644)
+	assert.NoError(t, err)
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+
+	for _, verbose := range []bool{false, true} {
+		plugin.VerboseResults = verbose
+		td, err := os.MkdirTemp("", "")
+		assert.NoError(t, err)
+		defer os.RemoveAll(td)
+		plugin.StateDir = td
+		eventBuf := new(bytes.Buffer)
+		report, err := processLogFile(logs[0], json.NewEncoder(eventBuf))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Matches)
+		var result Result
+		assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+		if verbose {
+			assert.Equal(t, map[string]string{"duration_ms": "7300"}, result.Fields)
+		} else {
+			assert.Nil(t, result.Fields)
+		}
+	}
+	clearPlugin()
+}
+
+func TestProcessLogFileWithSyslogFormatAndOutputTemplate(t *testing.T) {
+	clearPlugin()
+	plugin.Verbose = false
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.VerboseResults = true
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+	plugin.LogFormat = "syslog"
+	plugin.MatchExpr = "severity<=err AND app=sshd"
+	plugin.OutputTemplate = "{{ .Fields.hostname }} {{ .Fields.app }}[{{ .Fields.procid }}]: {{ .Fields.message }}{{ .Fields.missing }}"
+
+	td, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(td)
+	plugin.StateDir = td
+
+	logdir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logdir)
+	plugin.LogFile = filepath.Join(logdir, "secure")
+	content := "<38>Oct  7 08:01:00 web-1 sshd[4321]: Accepted publickey for deploy\n" +
+		"<35>Oct  7 08:01:02 web-1 sshd[4321]: Failed password for root\n" +
+		"<11>Oct  7 08:01:03 web-1 cron[77]: job failed\n"
+	err = os.WriteFile(plugin.LogFile, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	status, err := checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	eventBuf := new(bytes.Buffer)
+	enc, err := newTemplateEncoder(eventBuf, plugin.OutputTemplate)
+	assert.NoError(t, err)
+	report, err := processLogFile(logs[0], enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	assert.Equal(t, "web-1 sshd[4321]: Failed password for root\n", eventBuf.String())
+
+	plugin.OutputTemplate = "{{ .Fields.app"
+	_, err = checkArgs(nil)
+	assert.Error(t, err)
+	clearPlugin()
+}
+
+func TestBuildLogArrayWithNewestAndModifiedWithin(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	td := t.TempDir()
+	now := time.Now()
+	ages := map[string]time.Duration{
+		"app-1.log": 72 * time.Hour,
+		"app-2.log": 90 * time.Minute,
+		"app-3.log": 20 * time.Minute,
+		"app-4.log": 5 * time.Minute,
+	}
+	for name, age := range ages {
+		path := filepath.Join(td, name)
+		assert.NoError(t, os.WriteFile(path, []byte("test\n"), 0644))
+		assert.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+	plugin.LogPath = td
+	plugin.LogFileExpr = `app-.*\.log$`
+	plugin.UseLatestMtime = false
+
+	plugin.Newest = 3
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(td, "app-4.log"),
+		filepath.Join(td, "app-3.log"),
+		filepath.Join(td, "app-2.log"),
+	}, logs)
+
+	plugin.Newest = 0
+	plugin.ModifiedWithin = "1h"
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(td, "app-3.log"), filepath.Join(td, "app-4.log")}, logs)
+
+	// the selectors apply to the files found by --log-glob too
+	plugin.LogFileExpr = ""
+	plugin.LogGlobs = []string{filepath.Join(td, "*.log")}
+	plugin.ModifiedWithin = "2h"
+	plugin.Newest = 1
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(td, "app-4.log")}, logs)
+
+	plugin.LogFileExpr = `app-.*\.log$`
+	plugin.LogGlobs = nil
+	plugin.ModifiedWithin = ""
+	plugin.Newest = -1
+	status, err := checkArgs(nil)
+	assert.Error(t, err)
+	assert.Equal(t, 2, status)
+
+	plugin.Newest = 2
+	plugin.UseLatestMtime = true
+	_, err = checkArgs(nil)
+	assert.Error(t, err)
+
+	plugin.Newest = 0
+	plugin.UseLatestMtime = false
+	plugin.ModifiedWithin = "soon"
+	_, err = checkArgs(nil)
+	assert.Error(t, err)
+}
+
+func TestProcessLogFileWithPartialLine(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "brown cow"
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("what now brown cow\nthe brown"), 0644))
+	eventBuf := new(bytes.Buffer)
+	enc := json.NewEncoder(eventBuf)
+	report, err := processLogFile(plugin.LogFile, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	stateFile := testStateFile(t)
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("what now brown cow\n")), state.Offset)
+
+	// the line being written is read as a whole once complete
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(" cow\nand the brown cow")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	report, err = processLogFile(plugin.LogFile, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+
+	// with --partial-line-timeout, the final line is read once the file
+	// hasn't been modified for that long
+	plugin.PartialLineTimeout = "1m"
+	report, err = processLogFile(plugin.LogFile, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	past := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(plugin.LogFile, past, past))
+	report, err = processLogFile(plugin.LogFile, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+	info, err := os.Stat(plugin.LogFile)
+	assert.NoError(t, err)
+	state, err = getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), state.Offset)
+
+	plugin.PartialLineTimeout = "0s"
+	_, err = checkArgs(nil)
+	assert.Error(t, err)
+}
+
+func TestProcessLogFileSkipToEndWithPartialLine(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.VerboseResults = true
+	plugin.MatchExpr = "ERROR"
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+
+	for _, skip := range []string{"ignore-initial-run", "corrupt-state"} {
+		plugin.StateDir = t.TempDir()
+		plugin.IgnoreInitialRun = skip == "ignore-initial-run"
+		plugin.CorruptState = ""
+		if skip == "corrupt-state" {
+			plugin.CorruptState = "end"
+			assert.NoError(t, os.WriteFile(testStateFile(t), []byte{0x1f}, 0644))
+		}
+		assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("INFO ok\nINFO half wri"), 0644))
+		report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+		assert.NoError(t, err, skip)
+		assert.Equal(t, 0, report.Matches, skip)
+
+		// the line being written is skipped whole once complete
+		f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		_, err = f.WriteString("tten ERROR\n")
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		eventBuf := new(bytes.Buffer)
+		report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+		assert.NoError(t, err, skip)
+		assert.Equal(t, 1, report.Matches, skip)
+		var result Result
+		assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+		assert.Equal(t, "INFO half written ERROR\n", result.Match, skip)
+	}
+}
+
+func TestLastLineEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	long := strings.Repeat("x", 10000)
+	tests := []struct {
+		content string
+		end     int64
+	}{
+		{"", 0},
+		{"partial", 0},
+		{"line\n", 5},
+		{"line\npartial", 5},
+		{"line\n" + long, 5},
+		{long + "\n" + long, int64(len(long) + 1)},
+	}
+	for _, test := range tests {
+		assert.NoError(t, os.WriteFile(path, []byte(test.content), 0644))
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		end, err := lastLineEnd(f, int64(len(test.content)))
+		assert.NoError(t, err)
+		assert.Equal(t, test.end, end, len(test.content))
+		assert.NoError(t, f.Close())
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatchPattern is a named match expression with its own alerting thresholds.
+// With a structured --log-format the expression is a field expression,
+// except for the fallback pattern which is always an RE2 regexp.
+// A heartbeat pattern, with AbsentFor set, alerts when it hasn't matched for
+// that long instead of alerting on matches.
+type MatchPattern struct {
+	Name              string
+	Expr              string
+	WarningThreshold  int
+	CriticalThreshold int
+	Fallback          bool
+	AbsentFor         time.Duration
+}
+
+// parsePattern parses a --match-pattern specification of the form
+// "name=<name>,warning=<n>,critical=<n>,absent=<duration>,expr=<regexp>".
+// Everything after "expr=" is used verbatim, so the expression may itself
+// contain commas. Omitted thresholds default to --warning-threshold and
+// --critical-threshold.
+func parsePattern(spec string) (MatchPattern, error) {
+	p := MatchPattern{
+		WarningThreshold:  plugin.WarningThreshold,
+		CriticalThreshold: plugin.CriticalThreshold,
+	}
+	rest := spec
+	for len(rest) > 0 {
+		if strings.HasPrefix(rest, "expr=") {
+			p.Expr = strings.TrimPrefix(rest, "expr=")
+			break
+		}
+		field := rest
+		rest = ""
+		if i := strings.Index(field, ","); i >= 0 {
+			field, rest = field[:i], field[i+1:]
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return p, fmt.Errorf("invalid match pattern %q: expected key=value, got %q", spec, field)
+		}
+		switch key {
+		case "name":
+			p.Name = value
+		case "warning", "critical":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return p, fmt.Errorf("invalid match pattern %q: %s threshold %q is not a number", spec, key, value)
+			}
+			if key == "warning" {
+				p.WarningThreshold = n
+			} else {
+				p.CriticalThreshold = n
+			}
+		case "absent":
+			d, err := parsePositiveDuration(value)
+			if err != nil {
+				return p, fmt.Errorf("invalid match pattern %q: %s", spec, err)
+			}
+			p.AbsentFor = d
+		default:
+			return p, fmt.Errorf("invalid match pattern %q: unknown key %q", spec, key)
+		}
+	}
+	if p.Expr == "" {
+		return p, fmt.Errorf("invalid match pattern %q: expr not specified", spec)
+	}
+	if p.Name == "" {
+		p.Name = p.Expr
+	}
+	return p, nil
+}
+
+// buildPatterns returns the patterns to evaluate, the --match-expr pattern
+// (if any) first, followed by each --match-pattern in the order given.
+func buildPatterns() ([]MatchPattern, error) {
+	patterns := []MatchPattern{}
+	if plugin.MatchExpr != "" {
+		p := MatchPattern{
+			Name:              plugin.MatchExpr,
+			Expr:              plugin.MatchExpr,
+			WarningThreshold:  plugin.WarningThreshold,
+			CriticalThreshold: plugin.CriticalThreshold,
+		}
+		if plugin.AbsentFor != "" {
+			d, err := parsePositiveDuration(plugin.AbsentFor)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --absent-for: %s", err)
+			}
+			p.AbsentFor = d
+		}
+		patterns = append(patterns, p)
+	}
+	names := map[string]bool{}
+	for _, p := range patterns {
+		names[p.Name] = true
+	}
+	for _, spec := range plugin.MatchPatterns {
+		p, err := parsePattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate match pattern name %q", p.Name)
+		}
+		names[p.Name] = true
+		patterns = append(patterns, p)
+	}
+	if plugin.FallbackExpr != "" && !isTextFormat() {
+		if names["fallback"] {
+			return nil, fmt.Errorf("duplicate match pattern name %q", "fallback")
+		}
+		patterns = append(patterns, MatchPattern{
+			Name:              "fallback",
+			Expr:              plugin.FallbackExpr,
+			WarningThreshold:  plugin.WarningThreshold,
+			CriticalThreshold: plugin.CriticalThreshold,
+			Fallback:          true,
+		})
+	}
+	return patterns, nil
+}
+
+// parsePositiveDuration parses a duration such as "30m", which must be
+// positive.
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", value)
+	}
+	return d, nil
+}
+
+// isTextFormat reports whether match expressions are RE2 regexps applied to
+// the raw line, rather than field expressions.
+func isTextFormat() bool {
+	return plugin.LogFormat == "" || plugin.LogFormat == "text"
+}
+
+// validatePattern checks that the expression of a pattern compiles.
+func validatePattern(p MatchPattern) error {
+	p, err := expandPattern(p)
+	if err != nil {
+		return err
+	}
+	if isTextFormat() || p.Fallback {
+		if _, err := regexp.Compile(p.Expr); err != nil {
+			return fmt.Errorf("invalid regexp for match pattern %s: %s", p.Name, err)
+		}
+		return nil
+	}
+	if _, err := parseConditions(p.Expr); err != nil {
+		return fmt.Errorf("match pattern %s: %s", p.Name, err)
+	}
+	return nil
+}
+
+// buildAnalyzerFunc returns the AnalyzerFunc for the configured --log-format.
+func buildAnalyzerFunc(patterns []MatchPattern) AnalyzerFunc {
+	expanded := make([]MatchPattern, len(patterns))
+	for i, p := range patterns {
+		p, err := expandPattern(p)
+		if err != nil {
+			fatal("%s", err)
+		}
+		expanded[i] = p
+	}
+	patterns = expanded
+	if isTextFormat() {
+		return AnalyzePatterns(patterns, plugin.ExcludeExprs)
+	}
+	fieldPatterns := []MatchPattern{}
+	var fallback AnalyzerFunc
+	for _, p := range patterns {
+		if p.Fallback {
+			fallback = AnalyzePatterns([]MatchPattern{p}, plugin.ExcludeExprs)
+		} else {
+			fieldPatterns = append(fieldPatterns, p)
+		}
+	}
+	switch plugin.LogFormat {
+	case "json":
+		return AnalyzeJSON(fieldPatterns, plugin.ExcludeExprs, fallback)
+	case "logfmt":
+		return AnalyzeLogfmt(fieldPatterns, plugin.ExcludeExprs, fallback)
+	case "syslog":
+		return AnalyzeSyslog(fieldPatterns, plugin.ExcludeExprs, fallback)
+	}
+	fatal("unsupported log format: %s", plugin.LogFormat)
+	return nil
+}
+
+// matchFingerprint returns the value cached in State.MatchExpr so a change to
+// the matching configuration between runs can be detected, and hashed into
+// the name of the state file. With a single --match-expr this is the
+// expression itself, as in earlier releases.
+func matchFingerprint(patterns []MatchPattern) string {
+	exprs := make([]string, 0, len(patterns)+len(plugin.ExcludeExprs)+1)
+	if !isTextFormat() {
+		exprs = append(exprs, "format:"+plugin.LogFormat)
+	}
+	if grokEnabled() {
+		exprs = append(exprs, "grok")
+	}
+	for _, p := range patterns {
+		exprs = append(exprs, p.Expr)
+	}
+	for _, expr := range plugin.ExcludeExprs {
+		exprs = append(exprs, "!"+expr)
+	}
+	return strings.Join(exprs, "\n")
+}
+
+// AnalyzePatterns returns an AnalyzerFunc that evaluates every pattern against
+// each line in a single pass. The returned Result lists the name of each
+// pattern that matched, and holds the named capture groups of the matching
+// patterns as fields. Lines matching any of the exclude expressions are
+// dropped even when a pattern matched.
+func AnalyzePatterns(patterns []MatchPattern, excludes []string) AnalyzerFunc {
+	res := make([]*regexp.Regexp, len(patterns))
+	captures := make([]bool, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p.Expr)
+		if err != nil {
+			fatal("invalid regexp for match pattern %s: %s", p.Name, err)
+		}
+		res[i] = re
+		for _, name := range re.SubexpNames() {
+			captures[i] = captures[i] || name != ""
+		}
+	}
+	excludeRes := compileExcludes(excludes)
+	return func(b []byte) *Result {
+		var matched []string
+		var fields map[string]string
+		for i, re := range res {
+			if !captures[i] {
+				if re.Match(b) {
+					matched = append(matched, patterns[i].Name)
+				}
+				continue
+			}
+			loc := re.FindSubmatchIndex(b)
+			if loc == nil {
+				continue
+			}
+			matched = append(matched, patterns[i].Name)
+			if fields == nil {
+				fields = map[string]string{}
+			}
+			for j, name := range re.SubexpNames() {
+				if _, ok := fields[name]; ok || name == "" || loc[2*j] < 0 {
+					continue
+				}
+				fields[name] = string(b[loc[2*j]:loc[2*j+1]])
+			}
+		}
+		if len(matched) == 0 || excluded(excludeRes, b) {
+			return nil
+		}
+		return &Result{Match: string(b), Patterns: matched, Fields: fields}
+	}
+}
+
+func compileExcludes(excludes []string) []*regexp.Regexp {
+	excludeRes := make([]*regexp.Regexp, len(excludes))
+	for i, expr := range excludes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			fatal("invalid exclude regexp: %s", err)
+		}
+		excludeRes[i] = re
+	}
+	return excludeRes
+}
+
+// excluded reports whether a line matches any of the exclude expressions.
+func excluded(excludeRes []*regexp.Regexp, b []byte) bool {
+	for _, re := range excludeRes {
+		if re.Match(b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePattern(t *testing.T) {
+	clearPlugin()
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+
+	p, err := parsePattern("name=oom,warning=0,critical=1,expr=(?i)out of memory, killing")
+	assert.NoError(t, err)
+	assert.Equal(t, "oom", p.Name)
+	assert.Equal(t, "(?i)out of memory, killing", p.Expr)
+	assert.Equal(t, 0, p.WarningThreshold)
+	assert.Equal(t, 1, p.CriticalThreshold)
+
+	p, err = parsePattern("expr=ERROR")
+	assert.NoError(t, err)
+	assert.Equal(t, "ERROR", p.Name)
+	assert.Equal(t, 1, p.WarningThreshold)
+	assert.Equal(t, 5, p.CriticalThreshold)
+
+	_, err = parsePattern("name=missing")
+	assert.Error(t, err)
+	_, err = parsePattern("name=bad,warning=one,expr=ERROR")
+	assert.Error(t, err)
+	_, err = parsePattern("level=2,expr=ERROR")
+	assert.Error(t, err)
+	_, err = parsePattern("ERROR")
+	assert.Error(t, err)
+}
+
+func TestBuildPatterns(t *testing.T) {
+	clearPlugin()
+	plugin.MatchExpr = "ERROR"
+	plugin.MatchPatterns = []string{"name=fatal,expr=FATAL", "name=oom,expr=OOM"}
+	patterns, err := buildPatterns()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(patterns))
+	assert.Equal(t, "ERROR", patterns[0].Name)
+	assert.Equal(t, "fatal", patterns[1].Name)
+	assert.Equal(t, "ERROR\nFATAL\nOOM", matchFingerprint(patterns))
+
+	plugin.MatchPatterns = []string{"name=fatal,expr=FATAL", "name=fatal,expr=OOM"}
+	_, err = buildPatterns()
+	assert.Error(t, err)
+
+	plugin.MatchPatterns = nil
+	patterns, err = buildPatterns()
+	assert.NoError(t, err)
+	assert.Equal(t, "ERROR", matchFingerprint(patterns))
+
+	plugin.ExcludeExprs = []string{"health check"}
+	assert.Equal(t, "ERROR\n!health check", matchFingerprint(patterns))
+	clearPlugin()
+}
+
+func TestAnalyzePatterns(t *testing.T) {
+	analyzer := AnalyzePatterns([]MatchPattern{
+		{Name: "error", Expr: "ERROR"},
+		{Name: "oom", Expr: "OOM"},
+	}, nil)
+	assert.Nil(t, analyzer([]byte("INFO: all good")))
+	result := analyzer([]byte("ERROR: OOM killer invoked"))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"error", "oom"}, result.Patterns)
+	result = analyzer([]byte("OOM"))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"oom"}, result.Patterns)
+}
+
+func TestAnalyzePatternsAnchored(t *testing.T) {
+	tests := []struct {
+		Pattern    string
+		LogLines   [][]byte
+		ExpResults []*Result
+	}{
+		{
+			Pattern: `^FOO`,
+			LogLines: [][]byte{
+				[]byte(`FOO: yes`),
+				[]byte(` FOO: no`),
+			},
+			ExpResults: []*Result{
+				&Result{
+					Match:    "FOO: yes",
+					Patterns: []string{`^FOO`},
+				},
+				nil,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		analyzer := AnalyzePatterns([]MatchPattern{{Name: test.Pattern, Expr: test.Pattern}}, nil)
+		results := make([]*Result, 0)
+		for _, line := range test.LogLines {
+			results = append(results, analyzer(line))
+		}
+		if got, want := len(results), len(test.ExpResults); got != want {
+			t.Fatal("wrong number of results")
+		}
+		for i := range results {
+			if got, want := results[i], test.ExpResults[i]; !reflect.DeepEqual(got, want) {
+				t.Fatalf("bad result %d: got %v, want %v", i, got, want)
+			}
+		}
+	}
+}
+
+func TestAnalyzePatternsWithExcludes(t *testing.T) {
+	analyzer := AnalyzePatterns([]MatchPattern{
+		{Name: "error", Expr: "ERROR"},
+	}, []string{"connection reset by health checker", "^DEBUG"})
+	assert.NotNil(t, analyzer([]byte("ERROR: connection refused")))
+	assert.Nil(t, analyzer([]byte("ERROR: connection reset by health checker")))
+	assert.Nil(t, analyzer([]byte("DEBUG ERROR: retrying")))
+	assert.Nil(t, analyzer([]byte("INFO: connection reset by health checker")))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// headSize is the number of bytes at the start of a log file that are hashed
+// to recognize it, when it has at least that many.
+const headSize = 1024
+
+// fileID identifies a file independently of its path, so that a log file
+// replaced by rotation can be told apart from the file read before.
+type fileID struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+}
+
+// fileReplaced reports whether f is not the file the state was recorded for.
+// The file was replaced if its device and inode differ, as when rotated with
+// logrotate's create, or if its first bytes changed, as when rotated with
+// copytruncate and written past the cached offset before this run.
+func fileReplaced(f *os.File, info os.FileInfo, state State) bool {
+	if id, ok := getFileID(f, info); ok && state.FileID != (fileID{}) && id != state.FileID {
+		return true
+	}
+	if state.HeadSize == 0 {
+		return false
+	}
+	if info.Size() < state.HeadSize {
+		return true
+	}
+	head, err := hashHead(f, state.HeadSize)
+	return err != nil || !bytes.Equal(head, state.HeadHash)
+}
+
+// recordFile records the identity of f in the state, along with a hash of
+// its first bytes up to the offset read.
+func recordFile(f *os.File, info os.FileInfo, state *State) error {
+	state.FileID, _ = getFileID(f, info)
+	size := state.Offset
+	if size > headSize {
+		size = headSize
+	}
+	// the offset of a compressed file is in its decompressed contents
+	if size > info.Size() {
+		size = info.Size()
+	}
+	head, err := hashHead(f, size)
+	if err != nil {
+		return err
+	}
+	state.HeadSize = size
+	state.HeadHash = head
+	return nil
+}
+
+// hashHead returns the hash of the first size bytes of f, without moving
+// its offset.
+func hashHead(f *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// findPredecessor returns the path of the file that the log file was rotated
+// to, such as access.log.1 or access.log-20261017 for access.log, or "" if it
+// can't be found. The predecessor is the sibling with the device and inode
+// recorded in the state, as when rotated with logrotate's create, or else one
+// starting with the same bytes, as when rotated with copytruncate.
+func findPredecessor(file string, state State) string {
+	dir, base := filepath.Split(file)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	copied := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == base || !strings.HasPrefix(name, base) || !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		sameID, sameHead := matchesState(path, state)
+		if sameID {
+			return path
+		}
+		if sameHead && copied == "" {
+			copied = path
+		}
+	}
+	return copied
+}
+
+// matchesState reports whether the file at path has the identity recorded in
+// the state, and whether it starts with the same bytes and is at least as
+// large as the offset read.
+func matchesState(path string, state State) (bool, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() < state.Offset {
+		return false, false
+	}
+	id, ok := getFileID(f, info)
+	sameID := ok && state.FileID != (fileID{}) && id == state.FileID
+	if state.HeadSize == 0 {
+		return sameID, false
+	}
+	head, err := hashHead(f, state.HeadSize)
+	return sameID, err == nil && bytes.Equal(head, state.HeadHash)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessLogFileWithReplacedFile(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "app.log")
+	plugin.StateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("ERROR before rotation\n"), 0644))
+
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+
+	// rotated with logrotate's create, the new file growing past the old offset
+	assert.NoError(t, os.Rename(plugin.LogFile, plugin.LogFile+".1"))
+	content := "ERROR after rotation, first line\n" + strings.Repeat("INFO filler\n", 4) + "ERROR after rotation\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+
+	// rotated with copytruncate, then written past the old offset
+	content = "INFO after truncation\n" + strings.Repeat("INFO filler\n", 8) + "ERROR after truncation\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+
+	// appended to, without rotation
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("ERROR appended\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+}
+
+func TestProcessLogFileReadsRotatedPredecessor(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "access.log")
+	plugin.StateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("ERROR first\n"), 0644))
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+
+	// written to after the last run, then rotated with create
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("ERROR before rotation\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, os.WriteFile(plugin.LogFile+"-20261016", []byte("ERROR older rotation\n"), 0644))
+	assert.NoError(t, os.Rename(plugin.LogFile, plugin.LogFile+"-20261017"))
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("INFO after rotation\n"+strings.Repeat("ERROR after rotation\n", 2)), 0644))
+
+	eventBuf := new(bytes.Buffer)
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Matches)
+	var result Result
+	assert.NoError(t, json.NewDecoder(eventBuf).Decode(&result))
+	assert.Equal(t, plugin.LogFile+"-20261017", result.Path)
+	assert.Equal(t, int64(12), result.Offset)
+
+	// written to after the last run, then rotated with copytruncate
+	content, err := os.ReadFile(plugin.LogFile)
+	assert.NoError(t, err)
+	content = append(content, "ERROR before truncation\n"...)
+	assert.NoError(t, os.WriteFile(plugin.LogFile+".1", content, 0644))
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("INFO after truncation\n"), 0644))
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Matches)
+}
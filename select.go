@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// candidateFile is a log file found by --log-file-expr or --log-glob, along
+// with its modification time.
+type candidateFile struct {
+	path  string
+	mtime time.Time
+}
+
+// selectFiles returns the paths of the files modified within the last
+// modifiedWithin, if set, keeping only the newest of them when newest is
+// more than zero. Those are ordered by modification time, most recent first,
+// otherwise the files keep the order they were found in.
+func selectFiles(files []candidateFile, modifiedWithin time.Duration, newest int, now time.Time) []string {
+	selected := []candidateFile{}
+	for _, file := range files {
+		if modifiedWithin > 0 && now.Sub(file.mtime) > modifiedWithin {
+			if plugin.Verbose {
+				fmt.Printf("Skipping %s, not modified within %s (mtime: %v)\n", file.path, modifiedWithin, file.mtime)
+			}
+			continue
+		}
+		selected = append(selected, file)
+	}
+	if newest > 0 && len(selected) > newest {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].mtime.After(selected[j].mtime)
+		})
+		selected = selected[:newest]
+		if plugin.Verbose {
+			for _, file := range selected {
+				fmt.Printf("Using recently modified file: %s (mtime: %v)\n", file.path, file.mtime)
+			}
+		}
+	}
+	paths := make([]string, len(selected))
+	for i, file := range selected {
+		paths[i] = file.path
+	}
+	return paths
+}
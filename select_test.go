@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFiles(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	files := []candidateFile{
+		{path: "/var/log/a.log", mtime: now.Add(-2 * time.Hour)},
+		{path: "/var/log/b.log", mtime: now.Add(-10 * time.Minute)},
+		{path: "/var/log/c.log", mtime: now.Add(-30 * 24 * time.Hour)},
+		{path: "/var/log/d.log", mtime: now.Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name           string
+		modifiedWithin time.Duration
+		newest         int
+		want           []string
+	}{
+		{"all", 0, 0, []string{"/var/log/a.log", "/var/log/b.log", "/var/log/c.log", "/var/log/d.log"}},
+		{"newest", 0, 1, []string{"/var/log/d.log"}},
+		{"newest 3", 0, 3, []string{"/var/log/d.log", "/var/log/b.log", "/var/log/a.log"}},
+		{"newest more than found", 0, 10, []string{"/var/log/a.log", "/var/log/b.log", "/var/log/c.log", "/var/log/d.log"}},
+		{"modified within", time.Hour, 0, []string{"/var/log/b.log", "/var/log/d.log"}},
+		{"modified within and newest", 3 * time.Hour, 2, []string{"/var/log/d.log", "/var/log/b.log"}},
+		{"none modified within", 30 * time.Second, 0, []string{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, selectFiles(files, test.modifiedWithin, test.newest, now), test.name)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// staleFile is a log file that hasn't grown for longer than --max-file-age.
+type staleFile struct {
+	Path       string
+	LastGrowth time.Time
+}
+
+// staleFiles returns the files that haven't grown for longer than
+// --max-file-age, ordered by path.
+func staleFiles(files map[string]FileReport, maxAge time.Duration, now time.Time) []staleFile {
+	stale := []staleFile{}
+	if maxAge <= 0 {
+		return stale
+	}
+	for path, report := range files {
+		// not processed, such as when missing
+		if report.LastGrowth.IsZero() {
+			continue
+		}
+		if now.Sub(report.LastGrowth) > maxAge {
+			stale = append(stale, staleFile{Path: path, LastGrowth: report.LastGrowth})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Path < stale[j].Path
+	})
+	return stale
+}
+
+func staleOutput(stale []staleFile, now time.Time) string {
+	output := ""
+	for _, f := range stale {
+		output = output + fmt.Sprintf("File %s has not grown for %s, since %s\n", f.Path, now.Sub(f.LastGrowth).Truncate(time.Second), f.LastGrowth.Format(time.RFC3339))
+	}
+	return output
+}
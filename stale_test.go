@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleFiles(t *testing.T) {
+	now := time.Now()
+	files := map[string]FileReport{
+		"/var/log/b.log":   {LastGrowth: now.Add(-3 * time.Hour)},
+		"/var/log/a.log":   {LastGrowth: now.Add(-2 * time.Hour)},
+		"/var/log/new.log": {LastGrowth: now.Add(-time.Minute)},
+		"/var/log/missing": {},
+	}
+	stale := staleFiles(files, time.Hour, now)
+	if assert.Len(t, stale, 2) {
+		assert.Equal(t, "/var/log/a.log", stale[0].Path)
+		assert.Equal(t, "/var/log/b.log", stale[1].Path)
+	}
+	assert.True(t, strings.HasPrefix(staleOutput(stale, now), "File /var/log/a.log has not grown for 2h0m0s, since "))
+	assert.Len(t, staleFiles(files, 0, now), 0)
+}
+
+func TestExecuteCheckWithMaxFileAge(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "ERROR"
+	plugin.MaxFileAge = "1h"
+	plugin.WarningThreshold = 1
+	plugin.CriticalThreshold = 5
+
+	logdir := t.TempDir()
+	plugin.LogFile = filepath.Join(logdir, "daemon.log")
+	plugin.StateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("INFO started\n"), 0644))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(plugin.LogFile, old, old))
+	status, err := checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	// last written two hours ago
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+
+	f, err := os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("INFO still alive\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+
+	// touching the file doesn't count as growth
+	stateFile := testStateFile(t)
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	state.LastGrowth = old
+	assert.NoError(t, setState(state, stateFile))
+	now := time.Now()
+	assert.NoError(t, os.Chtimes(plugin.LogFile, now, now))
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status)
+
+	plugin.WarningOnly = true
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+	plugin.WarningOnly = false
+
+	// a partial line left unread is growth all the same
+	f, err = os.OpenFile(plugin.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("INFO still")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	status, err = executeCheck(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	state, err = getState(stateFile)
+	assert.NoError(t, err)
+	assert.Less(t, state.Offset, state.Size)
+
+	plugin.MaxFileAge = "recently"
+	status, err = checkArgs(nil)
+	assert.Error(t, err)
+	assert.Equal(t, 2, status)
+}
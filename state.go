@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateVersion is the version of the JSON state file format written.
+const stateVersion = 1
+
+// errCorruptState is returned by getState when a state file can't be
+// decoded, such as when the check was killed while writing it.
+var errCorruptState = errors.New("state file is corrupt")
+
+// stateFilePath returns the path of the state file of a log file in the
+// state directory. It's named after the path of the log file and a hash of
+// the matching configuration, so that checks matching the same log file
+// differently can share a state directory, each keeping its own offset.
+func stateFilePath(file string, fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return legacyStateFilePath(file) + "." + hex.EncodeToString(sum[:8])
+}
+
+// legacyStateFilePath returns the path of the state file of a log file as
+// named by earlier releases, after the path of the log file alone.
+func legacyStateFilePath(file string) string {
+	return filepath.Join(plugin.StateDir, strings.ReplaceAll(file, string(os.PathSeparator), "_"))
+}
+
+// adoptLegacyState renames the state file of a log file named by an earlier
+// release to stateFile, when it was written with the same matching
+// configuration, so the log file isn't read again from the start after an
+// upgrade. A legacy state file written with another configuration is left
+// for the check it belongs to.
+func adoptLegacyState(file, stateFile, fingerprint string) error {
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		return nil
+	}
+	legacy := legacyStateFilePath(file)
+	state, err := getState(legacy)
+	if err != nil || state.MatchExpr != fingerprint {
+		return nil
+	}
+	if plugin.Verbose {
+		fmt.Printf("Renaming state file %s to %s\n", legacy, stateFile)
+	}
+	return os.Rename(legacy, stateFile)
+}
+
+// getState reads the state file of a log file, returning an empty state if
+// there is none yet. State files written as gob by earlier releases are
+// migrated to JSON.
+func getState(path string) (State, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return State{}, nil
+		}
+		return State{}, fmt.Errorf("couldn't read state file: %s", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return migrateState(path, b)
+	}
+	var state State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return State{}, fmt.Errorf("couldn't read state file %s: %w: %s", path, errCorruptState, err)
+	}
+	if state.Version > stateVersion {
+		return State{}, fmt.Errorf("couldn't read state file %s: version %d is newer than the supported version %d", path, state.Version, stateVersion)
+	}
+	return state, nil
+}
+
+// migrateState decodes a state file written as gob by an earlier release,
+// and rewrites it as JSON so it's only migrated once.
+func migrateState(path string, b []byte) (State, error) {
+	var state State
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&state); err != nil {
+		return State{}, fmt.Errorf("couldn't read state file %s: %w: %s", path, errCorruptState, err)
+	}
+	if err := setState(state, path); err != nil {
+		return State{}, err
+	}
+	if plugin.Verbose {
+		fmt.Printf("Migrated state file %s to JSON\n", path)
+	}
+	return state, nil
+}
+
+// setState replaces the state file atomically, so it isn't left truncated
+// when the check is killed while writing it.
+func setState(cur State, path string) error {
+	cur.Version = stateVersion
+	err := writeFileAtomic(path, func(f *os.File) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cur)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write state file: %s", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file by way of a temporary file in the same
+// directory, which is synced and then renamed into place, so the file holds
+// either its previous or its new content even if the check is killed while
+// writing it.
+func writeFileAtomic(path string, write func(f *os.File) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// recoverState moves a corrupt state file out of the way, adding a .corrupt
+// suffix, so the log file can be resumed according to --corrupt-state. It
+// reports whether to resume from the end of the log file, rather than its
+// start. With --corrupt-state fail, cause is returned instead.
+func recoverState(stateFile string, cause error) (bool, error) {
+	if plugin.CorruptState == "fail" {
+		return false, cause
+	}
+	quarantined := stateFile + ".corrupt"
+	if err := os.Rename(stateFile, quarantined); err != nil {
+		return false, fmt.Errorf("couldn't move corrupt state file %s: %s", stateFile, err)
+	}
+	position := "start"
+	if plugin.CorruptState == "end" {
+		position = "end"
+	}
+	fmt.Printf("warning: %s, moved it to %s and resuming from the %s of the log file\n", cause, quarantined, position)
+	return position == "end", nil
+}
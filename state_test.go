@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStateFile returns the path of the state file of plugin.LogFile with
+// the current matching configuration.
+func testStateFile(t *testing.T) string {
+	patterns, err := buildPatterns()
+	require.NoError(t, err)
+	return stateFilePath(plugin.LogFile, matchFingerprint(patterns))
+}
+
+func TestSetStateAtomic(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "_var_log_app.log")
+	assert.NoError(t, setState(State{Offset: 10, MatchExpr: "error"}, stateFile))
+	assert.NoError(t, setState(State{Offset: 20, MatchExpr: "error"}, stateFile))
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), state.Offset)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// a failed write leaves the previous state in place
+	err = writeFileAtomic(stateFile, func(f *os.File) error {
+		if _, err := f.Write([]byte("partial")); err != nil {
+			return err
+		}
+		return errors.New("killed")
+	})
+	assert.Error(t, err)
+	state, err = getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), state.Offset)
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, os.WriteFile(stateFile, []byte("truncated"), 0644))
+	_, err = getState(stateFile)
+	assert.True(t, errors.Is(err, errCorruptState))
+	assert.NoError(t, os.WriteFile(stateFile, []byte{}, 0644))
+	_, err = getState(stateFile)
+	assert.True(t, errors.Is(err, errCorruptState))
+}
+
+func TestProcessLogFileWithCorruptState(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "brown cow"
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	content := "what now brown cow\nthe brown cow\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+	stateFile := testStateFile(t)
+	corrupt := func() {
+		assert.NoError(t, os.WriteFile(stateFile, []byte{0x1f}, 0644))
+	}
+
+	tests := []struct {
+		policy  string
+		matches int
+		offset  int64
+	}{
+		{"", 2, int64(len(content))},
+		{"start", 2, int64(len(content))},
+		{"end", 0, int64(len(content))},
+	}
+	for _, test := range tests {
+		plugin.CorruptState = test.policy
+		corrupt()
+		report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+		assert.NoError(t, err, test.policy)
+		assert.Equal(t, test.matches, report.Matches, test.policy)
+		state, err := getState(stateFile)
+		assert.NoError(t, err, test.policy)
+		assert.Equal(t, test.offset, state.Offset, test.policy)
+		quarantined, err := os.ReadFile(stateFile + ".corrupt")
+		assert.NoError(t, err, test.policy)
+		assert.Equal(t, []byte{0x1f}, quarantined, test.policy)
+		assert.NoError(t, os.Remove(stateFile+".corrupt"))
+	}
+
+	plugin.CorruptState = "fail"
+	corrupt()
+	_, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.Error(t, err)
+	_, err = os.Stat(stateFile + ".corrupt")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGetStateMigratesGob(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "brown cow"
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	content := "what now brown cow\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte(content), 0644))
+	stateFile := legacyStateFilePath(plugin.LogFile)
+
+	// as written by earlier releases
+	legacy := struct {
+		Offset    int64
+		MatchExpr string
+	}{Offset: int64(len(content)), MatchExpr: plugin.MatchExpr}
+	f, err := os.Create(stateFile)
+	require.NoError(t, err)
+	require.NoError(t, gob.NewEncoder(f).Encode(legacy))
+	require.NoError(t, f.Close())
+
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.Offset, state.Offset)
+	assert.Equal(t, legacy.MatchExpr, state.MatchExpr)
+	b, err := os.ReadFile(stateFile)
+	assert.NoError(t, err)
+	assert.True(t, json.Valid(b))
+	assert.Contains(t, string(b), `"version": 1`)
+	assert.Contains(t, string(b), `"match_fingerprint": "brown cow"`)
+
+	// no old content is alerted on after the upgrade
+	f, err = os.Create(stateFile)
+	require.NoError(t, err)
+	require.NoError(t, gob.NewEncoder(f).Encode(legacy))
+	require.NoError(t, f.Close())
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matches)
+	_, err = os.Stat(stateFile)
+	assert.True(t, os.IsNotExist(err))
+	state, err = getState(testStateFile(t))
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.Offset, state.Offset)
+}
+
+func TestStateFilePathByMatchConfiguration(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("what now brown cow\nthe brown cow\nthe red cow\n"), 0644))
+
+	assert.Equal(t, stateFilePath(plugin.LogFile, "brown"), stateFilePath(plugin.LogFile, "brown"))
+	assert.NotEqual(t, stateFilePath(plugin.LogFile, "brown"), stateFilePath(plugin.LogFile, "red"))
+	assert.True(t, strings.HasPrefix(stateFilePath(plugin.LogFile, "brown"), legacyStateFilePath(plugin.LogFile)+"."))
+
+	// checks sharing a state directory each read the file once
+	configs := []struct {
+		matchExpr    string
+		excludeExprs []string
+		matches      int
+	}{
+		{"cow", nil, 3},
+		{"cow", []string{"red"}, 2},
+		{"red", nil, 1},
+	}
+	for i := 0; i < 2; i++ {
+		for _, config := range configs {
+			plugin.MatchExpr = config.matchExpr
+			plugin.ExcludeExprs = config.excludeExprs
+			report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+			assert.NoError(t, err)
+			if i == 0 {
+				assert.Equal(t, config.matches, report.Matches, config)
+			} else {
+				assert.Equal(t, 0, report.Matches, config)
+			}
+		}
+	}
+	entries, err := os.ReadDir(plugin.StateDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, len(configs)*2) // with their lock files
+
+	// a legacy state file written by another configuration is left in place
+	plugin.MatchExpr = "brown"
+	plugin.ExcludeExprs = nil
+	legacy := legacyStateFilePath(plugin.LogFile)
+	assert.NoError(t, setState(State{Offset: 100, MatchExpr: "cow"}, legacy))
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	_, err = os.Stat(legacy)
+	assert.NoError(t, err)
+
+	// a state file edited to another configuration still needs a reset
+	stateFile := testStateFile(t)
+	assert.NoError(t, setState(State{MatchExpr: "red"}, stateFile))
+	_, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.Error(t, err)
+	plugin.EnableStateReset = true
+	report, err = processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+}
+
+func TestStateRecordsRuns(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.MatchExpr = "brown cow"
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("what now brown cow\nthe brown cow\n"), 0644))
+	stateFile := testStateFile(t)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := processLogFile(plugin.LogFile, json.NewEncoder(new(bytes.Buffer)))
+		assert.NoError(t, err)
+	}
+	state, err := getState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, stateVersion, state.Version)
+	assert.Equal(t, int64(3), state.Runs)
+	assert.Equal(t, int64(2), state.Matches)
+	assert.False(t, state.LastMatch.Before(start.Truncate(time.Second)))
+	assert.True(t, state.LastRun.After(state.LastMatch))
+
+	// written by a later release
+	state.Version = stateVersion + 1
+	b, err := json.Marshal(state)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(stateFile, b, 0644))
+	_, err = getState(stateFile)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, errCorruptState))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// walkFollowingSymlinks walks the tree under root like filepath.Walk, except
+// that symbolic links are followed, so fn is given the info of their target.
+// Each directory is visited once, going by its real path, which protects
+// against symbolic link loops.
+func walkFollowingSymlinks(root string, fn filepath.WalkFunc) error {
+	err := walkFollowing(root, fn, map[string]bool{})
+	if errors.Is(err, filepath.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+func walkFollowing(path string, fn filepath.WalkFunc, visited map[string]bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fn(path, nil, err)
+	}
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	if visited[real] {
+		return nil
+	}
+	visited[real] = true
+	if err := fn(path, info, nil); err != nil {
+		if errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if err := fn(path, info, err); !errors.Is(err, filepath.SkipDir) {
+			return err
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if err := walkFollowing(filepath.Join(path, entry.Name()), fn, visited); err != nil {
+			// returned for a file, skipping the rest of the directory
+			if errors.Is(err, filepath.SkipDir) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSymlinks replaces each path with the real path of the file it links
+// to, so that files reached through several links are processed, and keep
+// state, only once. Paths that can't be resolved are kept as they are.
+func resolveSymlinks(paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		resolved[i] = path
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			if abs, err := filepath.Abs(real); err == nil {
+				resolved[i] = abs
+			}
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// symlinkTree creates a tree of log files where logs/current links to the
+// real directory data/app, data/app/loop links back to data, and
+// logs/app.log links to a file in data/app.
+func symlinkTree(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need extra privileges on windows")
+	}
+	td := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "data", "app"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "logs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "data", "app", "app.log"), []byte("test\n"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(td, "data", "app"), filepath.Join(td, "logs", "current")))
+	require.NoError(t, os.Symlink(filepath.Join(td, "data"), filepath.Join(td, "data", "app", "loop")))
+	require.NoError(t, os.Symlink(filepath.Join(td, "data", "app", "app.log"), filepath.Join(td, "logs", "app.log")))
+	real, err := filepath.EvalSymlinks(td)
+	require.NoError(t, err)
+	return real
+}
+
+func TestWalkFollowingSymlinks(t *testing.T) {
+	td := symlinkTree(t)
+	var files []string
+	err := walkFollowingSymlinks(filepath.Join(td, "logs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(files)
+	assert.Equal(t, []string{
+		filepath.Join(td, "logs", "app.log"),
+		filepath.Join(td, "logs", "current", "app.log"),
+	}, files)
+
+	// SkipDir skips the directory it's returned for
+	files = nil
+	err = walkFollowingSymlinks(filepath.Join(td, "logs"), func(path string, info os.FileInfo, err error) error {
+		if info.IsDir() && info.Name() == "current" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(td, "logs", "app.log")}, files)
+}
+
+func TestBuildLogArrayFollowingSymlinks(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	td := symlinkTree(t)
+	realLog := filepath.Join(td, "data", "app", "app.log")
+
+	plugin.LogPath = filepath.Join(td, "logs")
+	plugin.LogFileExpr = `\.log$`
+	logs, err := buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(td, "logs", "app.log")}, logs)
+
+	plugin.FollowSymlinks = true
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{realLog}, logs)
+
+	plugin.LogFileExpr = ""
+	plugin.LogGlobs = []string{filepath.Join(td, "logs", "**", "*.log")}
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{realLog}, logs)
+
+	plugin.LogGlobs = nil
+	plugin.LogFile = filepath.Join(td, "logs", "current", "app.log")
+	logs, err = buildLogArray()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{realLog}, logs)
+}
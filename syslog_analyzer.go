@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogSeverities maps RFC 5424 severity names, and their common aliases, to
+// severity codes so that conditions such as `severity<=err` can be written.
+var syslogSeverities = map[string]float64{
+	"emerg":   0,
+	"panic":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"error":   3,
+	"warning": 4,
+	"warn":    4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+var syslogSeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogFacilities maps RFC 5424 facility names to facility codes.
+var syslogFacilities = map[string]float64{
+	"kern":         0,
+	"user":         1,
+	"mail":         2,
+	"daemon":       3,
+	"auth":         4,
+	"syslog":       5,
+	"lpr":          6,
+	"news":         7,
+	"uucp":         8,
+	"cron":         9,
+	"authpriv":     10,
+	"ftp":          11,
+	"ntp":          12,
+	"security":     13,
+	"console":      14,
+	"solaris-cron": 15,
+	"local0":       16,
+	"local1":       17,
+	"local2":       18,
+	"local3":       19,
+	"local4":       20,
+	"local5":       21,
+	"local6":       22,
+	"local7":       23,
+}
+
+var syslogFacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// AnalyzeSyslog returns an AnalyzerFunc that parses each line as an RFC 3164
+// or RFC 5424 syslog message and evaluates the field conditions of every
+// pattern against its facility, severity, hostname, app, procid, msgid and
+// message. All parsed fields are included in the Result. Lines that aren't
+// syslog messages are handed to fallback, or skipped if fallback is nil.
+func AnalyzeSyslog(patterns []MatchPattern, excludes []string, fallback AnalyzerFunc) AnalyzerFunc {
+	return analyzeRecords(patterns, excludes, fallback, parseSyslogRecord, true)
+}
+
+// parseSyslogRecord parses a syslog line. The <PRI> header is optional, as
+// files written by syslog daemons usually omit it, in which case the record
+// has no facility or severity.
+func parseSyslogRecord(b []byte) (map[string]interface{}, bool) {
+	s := strings.TrimRight(string(b), "\r\n")
+	record := map[string]interface{}{}
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end < 2 || end > 4 {
+			return nil, false
+		}
+		pri, err := strconv.Atoi(s[1:end])
+		if err != nil || pri < 0 || pri > 191 {
+			return nil, false
+		}
+		record["priority"] = strconv.Itoa(pri)
+		record["facility"] = syslogFacilityNames[pri/8]
+		record["severity"] = syslogSeverityNames[pri%8]
+		s = s[end+1:]
+		if version, rest, ok := strings.Cut(s, " "); ok && version != "" && len(version) <= 2 && isDigits(version) {
+			record["version"] = version
+			return parseRFC5424(rest, record)
+		}
+	}
+	return parseRFC3164(s, record)
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss host tag[pid]: message", also
+// accepting an RFC 3339 timestamp as written by rsyslog's high precision
+// file format.
+func parseRFC3164(s string, record map[string]interface{}) (map[string]interface{}, bool) {
+	if len(s) >= 15 {
+		if _, err := time.Parse(time.Stamp, s[:15]); err == nil {
+			record["timestamp"] = s[:15]
+			s = s[15:]
+		}
+	}
+	if _, ok := record["timestamp"]; !ok {
+		ts, rest, _ := strings.Cut(s, " ")
+		if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+			return nil, false
+		}
+		record["timestamp"] = ts
+		s = rest
+	}
+	hostname, s, ok := strings.Cut(strings.TrimLeft(s, " "), " ")
+	if !ok || hostname == "" {
+		return nil, false
+	}
+	record["hostname"] = hostname
+	s = strings.TrimLeft(s, " ")
+	tag, msg, ok := strings.Cut(s, " ")
+	if ok && strings.HasSuffix(tag, ":") {
+		tag = strings.TrimSuffix(tag, ":")
+		app, pid, hasPid := strings.Cut(tag, "[")
+		record["app"] = app
+		if hasPid {
+			record["procid"] = strings.TrimSuffix(pid, "]")
+		}
+		s = msg
+	}
+	record["message"] = s
+	return record, true
+}
+
+// parseRFC5424 parses "timestamp host app procid msgid [sd] message", the
+// part of an RFC 5424 message following the version.
+func parseRFC5424(s string, record map[string]interface{}) (map[string]interface{}, bool) {
+	for _, field := range []string{"timestamp", "hostname", "app", "procid", "msgid"} {
+		value, rest, ok := strings.Cut(s, " ")
+		if !ok || value == "" {
+			return nil, false
+		}
+		if value != "-" {
+			record[field] = value
+		}
+		s = rest
+	}
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		end := structuredDataEnd(s)
+		if end < 0 {
+			return nil, false
+		}
+		record["structured_data"] = s[:end]
+		s = s[end:]
+	} else {
+		return nil, false
+	}
+	s = strings.TrimPrefix(s, " ")
+	record["message"] = strings.TrimPrefix(s, "\ufeff")
+	return record, true
+}
+
+// structuredDataEnd returns the index following the last of one or more
+// [id param="value"] elements at the start of s, or -1 if they are not
+// terminated.
+func structuredDataEnd(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; i < len(s); i++ {
+			if quoted && s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				quoted = !quoted
+			} else if s[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			return -1
+		}
+		i++
+	}
+	return i
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyslogRecord(t *testing.T) {
+	tests := []struct {
+		Line   string
+		Record map[string]interface{}
+	}{
+		{
+			Line: "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8\n",
+			Record: map[string]interface{}{
+				"priority":  "34",
+				"facility":  "auth",
+				"severity":  "crit",
+				"timestamp": "Oct 11 22:14:15",
+				"hostname":  "mymachine",
+				"app":       "su",
+				"message":   "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			Line: "Oct  7 08:01:02 web-1 sshd[4321]: Failed password for root from 10.0.0.1 port 22 ssh2\n",
+			Record: map[string]interface{}{
+				"timestamp": "Oct  7 08:01:02",
+				"hostname":  "web-1",
+				"app":       "sshd",
+				"procid":    "4321",
+				"message":   "Failed password for root from 10.0.0.1 port 22 ssh2",
+			},
+		},
+		{
+			Line: "2026-10-17T10:00:00.123456+00:00 web-1 kernel: Out of memory: Killed process 42\n",
+			Record: map[string]interface{}{
+				"timestamp": "2026-10-17T10:00:00.123456+00:00",
+				"hostname":  "web-1",
+				"app":       "kernel",
+				"message":   "Out of memory: Killed process 42",
+			},
+		},
+		{
+			Line: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventID="1011" note="a \"]\" b"] An application event log entry...`,
+			Record: map[string]interface{}{
+				"priority":        "165",
+				"facility":        "local4",
+				"severity":        "notice",
+				"version":         "1",
+				"timestamp":       "2003-10-11T22:14:15.003Z",
+				"hostname":        "mymachine.example.com",
+				"app":             "evntslog",
+				"msgid":           "ID47",
+				"structured_data": `[exampleSDID@32473 iut="3" eventID="1011" note="a \"]\" b"]`,
+				"message":         "An application event log entry...",
+			},
+		},
+		{
+			Line: "<11>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.",
+			Record: map[string]interface{}{
+				"priority":  "11",
+				"facility":  "user",
+				"severity":  "err",
+				"version":   "1",
+				"timestamp": "2003-08-24T05:14:15.000003-07:00",
+				"hostname":  "192.0.2.1",
+				"app":       "myproc",
+				"procid":    "8710",
+				"message":   "%% It's time to make the do-nuts.",
+			},
+		},
+	}
+	for _, test := range tests {
+		record, ok := parseSyslogRecord([]byte(test.Line))
+		assert.True(t, ok, test.Line)
+		assert.Equal(t, test.Record, record, test.Line)
+	}
+
+	for _, line := range []string{
+		"not a syslog line",
+		"<999>Oct 11 22:14:15 mymachine su: failed",
+		"<13>1 2003-10-11T22:14:15.003Z host app - ID47 [unterminated",
+		`{"level":"error"}`,
+	} {
+		_, ok := parseSyslogRecord([]byte(line))
+		assert.False(t, ok, line)
+	}
+}
+
+func TestSyslogAnalyzer(t *testing.T) {
+	patterns := []MatchPattern{
+		{Name: "sshd", Expr: "severity<=err AND app=sshd"},
+		{Name: "auth", Expr: "facility in (auth,authpriv) AND message =~ /Failed password/"},
+	}
+	analyzer := AnalyzeSyslog(patterns, nil, nil)
+
+	result := analyzer([]byte("<35>Oct  7 08:01:02 web-1 sshd[4321]: Failed password for root\n"))
+	assert.NotNil(t, result)
+	assert.Equal(t, []string{"sshd", "auth"}, result.Patterns)
+	assert.Equal(t, "web-1", result.Fields["hostname"])
+	assert.Equal(t, "4321", result.Fields["procid"])
+	assert.Equal(t, "err", result.Fields["severity"])
+
+	result = analyzer([]byte("<38>Oct  7 08:01:02 web-1 sshd[4321]: Accepted publickey for root\n"))
+	assert.Nil(t, result)
+	result = analyzer([]byte("<11>Oct  7 08:01:02 web-1 cron[1]: job failed\n"))
+	assert.Nil(t, result)
+
+	// without a <PRI> header there is no severity to compare
+	result = analyzer([]byte("Oct  7 08:01:02 web-1 sshd[4321]: error: kex failed\n"))
+	assert.Nil(t, result)
+
+	analyzer = AnalyzeSyslog([]MatchPattern{{Name: "three", Expr: "severity=3"}}, nil, nil)
+	assert.NotNil(t, analyzer([]byte("<11>Oct  7 08:01:02 web-1 cron[1]: job failed\n")))
+}
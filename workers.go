@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// resultRecorder is a resultEncoder holding the results of a single log file,
+// so that files processed concurrently can have their results encoded in a
+// deterministic order afterwards.
+type resultRecorder struct {
+	results []interface{}
+}
+
+func (r *resultRecorder) Encode(v interface{}) error {
+	r.results = append(r.results, v)
+	return nil
+}
+
+// fileOutcome is the outcome of processLogFile for one log file.
+type fileOutcome struct {
+	report   FileReport
+	err      error
+	recorder *resultRecorder
+}
+
+// processLogFiles runs processLogFile for each of the files, with up to
+// --file-workers files processed at a time, and returns the report of each
+// file along with the errors in the order of files. Results are encoded with
+// enc in the order of files too, whatever order the files finish in. As each
+// file is analyzed by --analyzer-procs goroutines, at most --file-workers
+// times that many analyze lines at once.
+func processLogFiles(files []string, enc resultEncoder) (map[string]FileReport, []error) {
+	reports := make(map[string]FileReport, len(files))
+	fileErrors := []error{}
+	workers := plugin.FileWorkers
+	if workers > len(files) {
+		workers = len(files)
+	}
+	if workers <= 1 {
+		for _, file := range files {
+			report, err := processLogFile(file, enc)
+			reports[file] = report
+			if err != nil {
+				fileErrors = append(fileErrors, err)
+			}
+		}
+		return reports, fileErrors
+	}
+
+	outcomes := make([]fileOutcome, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				recorder := &resultRecorder{}
+				report, err := processLogFile(files[i], recorder)
+				outcomes[i] = fileOutcome{report: report, err: err, recorder: recorder}
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, file := range files {
+		outcome := outcomes[i]
+		reports[file] = outcome.report
+		if outcome.err != nil {
+			fileErrors = append(fileErrors, outcome.err)
+		}
+		for _, result := range outcome.recorder.results {
+			if err := enc.Encode(result); err != nil {
+				fileErrors = append(fileErrors, fmt.Errorf("error couldn't encode result %+v for file %s: %s", result, file, err))
+				break
+			}
+		}
+	}
+	return reports, fileErrors
+}
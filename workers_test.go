@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessLogFilesWithWorkers(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.MatchExpr = "error"
+	plugin.VerboseResults = true
+
+	logdir := t.TempDir()
+	files := []string{}
+	for i := 0; i < 20; i++ {
+		file := filepath.Join(logdir, fmt.Sprintf("app-%02d.log", i))
+		content := ""
+		for j := 0; j <= i%4; j++ {
+			content += fmt.Sprintf("error %d in file %d\nok\n", j, i)
+		}
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+		files = append(files, file)
+	}
+	files = append(files, filepath.Join(logdir, "missing.log"))
+
+	run := func(workers int) (map[string]FileReport, []error, string) {
+		plugin.FileWorkers = workers
+		plugin.StateDir = t.TempDir()
+		eventBuf := new(bytes.Buffer)
+		reports, errs := processLogFiles(files, json.NewEncoder(eventBuf))
+		return reports, errs, eventBuf.String()
+	}
+	reports, errs, output := run(1)
+	assert.Len(t, reports, 21)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, 1, reports[files[0]].Matches)
+	assert.Equal(t, 4, reports[files[3]].Matches)
+
+	for _, workers := range []int{2, 8, 50} {
+		workerReports, workerErrs, workerOutput := run(workers)
+		assert.Equal(t, errs, workerErrs, workers)
+		assert.Equal(t, output, workerOutput, workers)
+		for file, report := range reports {
+			assert.Equal(t, report.Matches, workerReports[file].Matches, file)
+		}
+	}
+}
+
+func TestProcessLogFileWithLongLines(t *testing.T) {
+	clearPlugin()
+	defer clearPlugin()
+	plugin.Procs = 1
+	plugin.DisableEvent = true
+	plugin.VerboseResults = true
+	plugin.MatchExpr = "ERROR"
+	plugin.StateDir = t.TempDir()
+	plugin.LogFile = filepath.Join(t.TempDir(), "test.log")
+	long := "ERROR " + strings.Repeat("x", 3*readBufSize) + "\n"
+	assert.NoError(t, os.WriteFile(plugin.LogFile, []byte("INFO ok\n"+long+"ERROR end\n"), 0644))
+
+	// lines longer than the read buffer are read whole
+	eventBuf := new(bytes.Buffer)
+	report, err := processLogFile(plugin.LogFile, json.NewEncoder(eventBuf))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matches)
+	dec := json.NewDecoder(eventBuf)
+	var result Result
+	assert.NoError(t, dec.Decode(&result))
+	assert.Equal(t, int64(8), result.Offset)
+	assert.Equal(t, long, result.Match)
+	assert.NoError(t, dec.Decode(&result))
+	assert.Equal(t, int64(8+len(long)), result.Offset)
+}